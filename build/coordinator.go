@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kisielk/gosrc"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// workItem is handed to a remote worker by the coordinator.
+type workItem struct {
+	Path    string
+	Depth   int
+	Options gosrc.ListEntry
+
+	// Revision and GoVersion identify the stored results for Path, if they
+	// can be reused when the package hasn't changed.
+	Revision  string
+	GoVersion string
+}
+
+// workResult is posted back to the coordinator by a remote worker.
+type workResult struct {
+	Path  string
+	Depth int
+
+	Package gosrc.Package
+	Cached  bool // the stored results are still up to date
+
+	DownloadError string
+	Transient     bool
+	Attempts      int
+
+	// Root and Packages are the repository fetched for Path and the
+	// packages in it, if Path was the first of them the worker downloaded.
+	Root     string
+	Packages []string
+}
+
+const (
+	workPath   = "/work"
+	resultPath = "/result"
+
+	// workPollTimeout is how long a request for work waits for a package
+	// to become available before the coordinator answers with no content.
+	workPollTimeout = 30 * time.Second
+)
+
+// workDoneStatus is the status with which the coordinator answers requests
+// for work once the crawl has finished, telling workers to exit.
+const workDoneStatus = http.StatusGone
+
+// coordinator hands out packages to remote workers over HTTP and collects
+// their results.
+type coordinator struct {
+	collection      gosrc.Collection
+	requests        chan crawlItem
+	downloadResults chan downloadResult
+	buildResults    chan buildResult
+	done            chan struct{} // closed when the crawl has finished
+
+	mu      sync.Mutex
+	leases  map[string]time.Time // path to lease expiry
+	items   map[string]crawlItem // leased items
+	expired []crawlItem
+}
+
+// startCoordinator starts handing out the packages sent on downloadQueue to
+// remote workers. The returned function must be called once the crawl has
+// finished, to tell the workers to exit.
+func startCoordinator(collection gosrc.Collection, addr string, state *crawlState, downloadQueue chan crawlItem) (chan downloadResult, chan buildResult, func()) {
+	c := &coordinator{
+		collection:      collection,
+		requests:        make(chan crawlItem),
+		downloadResults: make(chan downloadResult),
+		buildResults:    make(chan buildResult),
+		done:            make(chan struct{}),
+		leases:          make(map[string]time.Time),
+		items:           make(map[string]crawlItem),
+	}
+
+	queue := newCrawlQueue(orderings[*order])
+	for p := range state.Done {
+		queue.MarkSeen(p)
+	}
+	for _, item := range state.Items() {
+		queue.Push(item)
+	}
+	go runQueue(queue, downloadQueue, c.requests)
+	go c.expireLeases(*leaseTimeout)
+
+	go func() {
+		log.Println("coordinator listening on", addr)
+		log.Fatal(http.ListenAndServe(addr, c.handler()))
+	}()
+	return c.downloadResults, c.buildResults, c.finish
+}
+
+// finish tells workers that the crawl has finished. Every package has been
+// built by the time it is called, so no worker holds a lease and each is
+// waiting for work or about to ask for it; finish keeps answering them for
+// one poll timeout before returning.
+func (c *coordinator) finish() {
+	log.Println("telling workers the crawl has finished")
+	close(c.done)
+	time.Sleep(workPollTimeout)
+}
+
+func (c *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(workPath, c.handleWork)
+	mux.HandleFunc(resultPath, c.handleResult)
+	return mux
+}
+
+// next returns the next item to hand out, preferring items whose lease
+// has expired. It returns errCrawlDone once the crawl has finished.
+func (c *coordinator) next() (crawlItem, bool, error) {
+	c.mu.Lock()
+	if n := len(c.expired); n > 0 {
+		item := c.expired[n-1]
+		c.expired = c.expired[:n-1]
+		c.mu.Unlock()
+		return item, true, nil
+	}
+	c.mu.Unlock()
+
+	select {
+	case item := <-c.requests:
+		return item, true, nil
+	case <-c.done:
+		return crawlItem{}, false, errCrawlDone
+	case <-time.After(workPollTimeout):
+		return crawlItem{}, false, nil
+	}
+}
+
+func (c *coordinator) handleWork(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	item, ok, err := c.next()
+	if err == errCrawlDone {
+		w.WriteHeader(workDoneStatus)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	c.mu.Lock()
+	c.leases[item.path] = time.Now().Add(*leaseTimeout)
+	c.items[item.path] = item
+	c.mu.Unlock()
+
+	work := workItem{Path: item.path, Depth: item.depth, Options: item.options}
+	if !*force {
+		if existing, err := c.collection.Get(item.path); err == nil && reusable(existing, item.options) {
+			work.Revision = existing.Repository.Revision.Id
+			work.GoVersion = existing.GoVersion
+		}
+	}
+	log.Println(item.path, "handed out to", req.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(work); err != nil {
+		log.Println(item.path, "failed to send work:", err)
+	}
+}
+
+func (c *coordinator) handleResult(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var res workResult
+	if err := json.NewDecoder(req.Body).Decode(&res); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	item, leased := c.items[res.Path]
+	delete(c.leases, res.Path)
+	delete(c.items, res.Path)
+	c.mu.Unlock()
+	if !leased {
+		// The lease expired and the package was handed to another worker.
+		log.Println(res.Path, "ignoring result from", req.RemoteAddr, "without a lease")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	switch {
+	case res.DownloadError != "":
+		c.downloadResults <- downloadResult{
+			item:     item,
+			attempts: res.Attempts,
+			err:      &downloadError{err: errors.New(res.DownloadError), transient: res.Transient},
+		}
+	case res.Cached:
+		existing, err := c.collection.Get(res.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			c.requeue(item)
+			return
+		}
+		cached := !applyOptions(&existing, item.options)
+		c.buildResults <- buildResult{pkg: existing, depth: item.depth, cached: cached, root: res.Root, packages: res.Packages}
+	default:
+		c.buildResults <- buildResult{pkg: res.Package, depth: item.depth, root: res.Root, packages: res.Packages}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *coordinator) requeue(item crawlItem) {
+	c.mu.Lock()
+	c.expired = append(c.expired, item)
+	c.mu.Unlock()
+}
+
+// expireLeases periodically makes packages whose lease has expired
+// available to other workers.
+func (c *coordinator) expireLeases(timeout time.Duration) {
+	interval := timeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	for now := range time.Tick(interval) {
+		c.mu.Lock()
+		for path, expiry := range c.leases {
+			if now.After(expiry) {
+				log.Println(path, "lease expired")
+				c.expired = append(c.expired, c.items[path])
+				delete(c.leases, path)
+				delete(c.items, path)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/kisielk/gosrc"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCoordinatorProtocol(t *testing.T) {
+	c := &coordinator{
+		collection:      gosrc.NewMemoryCollection(),
+		requests:        make(chan crawlItem, 1),
+		downloadResults: make(chan downloadResult, 1),
+		buildResults:    make(chan buildResult, 1),
+		done:            make(chan struct{}),
+		leases:          make(map[string]time.Time),
+		items:           make(map[string]crawlItem),
+	}
+	ts := httptest.NewServer(c.handler())
+	defer ts.Close()
+
+	c.requests <- crawlItem{path: "example.com/a", depth: 2}
+	work, ok, err := requestWork(ts.URL)
+	if err != nil || !ok {
+		t.Fatalf("requestWork: got %v, %v", ok, err)
+	}
+	if work.Path != "example.com/a" || work.Depth != 2 {
+		t.Fatalf("got work %+v", work)
+	}
+
+	res := workResult{Path: work.Path, Depth: work.Depth}
+	res.Package.ImportPath = work.Path
+	if err := postResult(ts.URL, res); err != nil {
+		t.Fatal(err)
+	}
+	r := <-c.buildResults
+	if r.pkg.ImportPath != "example.com/a" || r.depth != 2 || r.cached {
+		t.Fatalf("got build result %+v", r)
+	}
+
+	// A second result for the same package has no lease and is dropped.
+	if err := postResult(ts.URL, res); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-c.buildResults:
+		t.Fatalf("got unexpected build result %+v", r)
+	default:
+	}
+
+	c.requests <- crawlItem{path: "example.com/b", depth: 1}
+	work, _, _ = requestWork(ts.URL)
+	res = workResult{Path: work.Path, DownloadError: "exit status 1", Transient: true, Attempts: 4}
+	if err := postResult(ts.URL, res); err != nil {
+		t.Fatal(err)
+	}
+	d := <-c.downloadResults
+	if d.item.path != "example.com/b" || d.attempts != 4 || !isTransient(d.err) {
+		t.Fatalf("got download result %+v", d)
+	}
+
+	// Once the crawl has finished, workers are told to exit.
+	close(c.done)
+	if _, ok, err := requestWork(ts.URL); ok || err != errCrawlDone {
+		t.Fatalf("requestWork after finish: got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"github.com/kisielk/gosrc"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// project is a local Go project whose dependencies are crawled by -deps.
+type project struct {
+	Dir        string
+	ImportPath string
+
+	// Deps maps the packages outside the standard library that the
+	// project's packages or their tests import, directly or indirectly, to
+	// the number of imports between them and the project.
+	Deps map[string]int
+
+	// Modules maps the modules required in the project's go.mod, if it has
+	// one, to the revisions to crawl their packages at.
+	Modules map[string]string
+}
+
+// loadProject finds the dependencies of the project in dir. Its import path
+// is the module path in dir/go.mod, or else its path within the GOPATH.
+// Dependencies already in gopath are followed; the imports of the rest are
+// left for the crawl to discover.
+func loadProject(gopath, dir string) (*project, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	importPath, err := projectImportPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	own, err := gosrc.TreeSource{Dir: dir, Prefix: importPath}.Packages()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := build.Default
+	ctx.GOPATH = gopath
+	p := &project{Dir: dir, ImportPath: importPath, Deps: make(map[string]int)}
+	if p.Modules, err = moduleRequirements(filepath.Join(dir, "go.mod")); err != nil {
+		return nil, err
+	}
+	var queue []string
+	add := func(imp string, depth int) {
+		if _, ok := p.Deps[imp]; ok || p.Contains(imp) || imp == "C" || build.IsLocalImport(imp) {
+			return
+		}
+		if isGoroot(imp) {
+			return
+		}
+		p.Deps[imp] = depth
+		queue = append(queue, imp)
+	}
+
+	for _, pkg := range own {
+		rel := strings.TrimPrefix(strings.TrimPrefix(pkg, importPath), "/")
+		bp, err := ctx.ImportDir(filepath.Join(dir, filepath.FromSlash(rel)), 0)
+		if _, ok := err.(*build.NoGoError); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, imp := range append(append(bp.Imports, bp.TestImports...), bp.XTestImports...) {
+			add(imp, 0)
+		}
+	}
+	for len(queue) > 0 {
+		imp := queue[0]
+		queue = queue[1:]
+		bp, err := ctx.Import(imp, "", 0)
+		if err != nil {
+			continue
+		}
+		for _, next := range bp.Imports {
+			add(next, p.Deps[imp]+1)
+		}
+	}
+	return p, nil
+}
+
+// isGoroot reports whether imp is in the standard library.
+func isGoroot(imp string) bool {
+	bp, err := build.Import(imp, "", build.FindOnly)
+	return err == nil && bp.Goroot
+}
+
+// projectImportPath returns the import path of the project in dir.
+func projectImportPath(dir string) (string, error) {
+	gomod := filepath.Join(dir, "go.mod")
+	if _, err := os.Stat(gomod); err == nil {
+		return gosrc.ModulePath(gomod)
+	}
+	bp, err := build.ImportDir(dir, build.FindOnly)
+	if err == nil && !build.IsLocalImport(bp.ImportPath) && !strings.HasPrefix(bp.ImportPath, "_") {
+		return bp.ImportPath, nil
+	}
+	return "", fmt.Errorf("%s has no go.mod and is not in the GOPATH", dir)
+}
+
+// Contains reports whether pkg is one of the project's own packages.
+func (p *project) Contains(pkg string) bool {
+	return pkg == p.ImportPath || strings.HasPrefix(pkg, p.ImportPath+"/")
+}
+
+// Entries returns the project's dependencies no more than maxDepth imports
+// away, or all of them if maxDepth is negative, as package list entries
+// pinned to the revisions required by the project.
+func (p *project) Entries(maxDepth int) []gosrc.ListEntry {
+	var deps []string
+	for imp, depth := range p.Deps {
+		if maxDepth < 0 || depth <= maxDepth {
+			deps = append(deps, imp)
+		}
+	}
+	sort.Strings(deps)
+	var entries []gosrc.ListEntry
+	for _, imp := range deps {
+		entries = append(entries, gosrc.ListEntry{ImportPath: imp, Revision: moduleRevision(p.Modules, imp)})
+	}
+	return entries
+}
+
+// moduleRequirements reads the modules required by a go.mod file, mapped to
+// the revisions of their repositories to check out: the tag of a release,
+// or the commit of a pseudo-version. It returns nil if there is no go.mod.
+// Replace directives aren't taken into account.
+func moduleRequirements(gomod string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(gomod)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	modules := make(map[string]string)
+	block := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case block && fields[0] == ")":
+			block = false
+			continue
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			block = true
+			continue
+		case fields[0] == "require":
+			fields = fields[1:]
+		case !block:
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s: malformed requirement %q", gomod, strings.TrimSpace(line))
+		}
+		mod, version := strings.Trim(fields[0], `"`), strings.Trim(fields[1], `"`)
+		modules[mod] = versionRevision(version)
+	}
+	return modules, nil
+}
+
+var pseudoVersion = regexp.MustCompile(`[-.][0-9]{14}-([0-9a-f]{12})$`)
+
+// versionRevision returns the revision a module version refers to: the
+// commit hash of a pseudo-version, or else the version's tag.
+func versionRevision(version string) string {
+	version = strings.TrimSuffix(version, "+incompatible")
+	if m := pseudoVersion.FindStringSubmatch(version); m != nil {
+		return m[1]
+	}
+	return version
+}
+
+// moduleRevision returns the revision in modules of the module containing
+// pkg, or "" if none does.
+func moduleRevision(modules map[string]string, pkg string) string {
+	for p := pkg; p != "."; p = path.Dir(p) {
+		if rev, ok := modules[p]; ok {
+			return rev
+		}
+	}
+	return ""
+}
+
+// writeHealth writes a table summarizing the results in collection for
+// packages, which are the dependencies of a project. A dependency is failing
+// if it could not be built or its tests failed.
+func writeHealth(w io.Writer, collection gosrc.Collection, packages []string) error {
+	sort.Strings(packages)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tDEPTH\tBUILD\tTEST\tVET\tRELEASE\tACTIVITY\tIMPORT PATH")
+	var failed int
+	for _, pkg := range packages {
+		p, err := collection.Get(pkg)
+		if err != nil {
+			fmt.Fprintf(tw, "%s\t\tno results: %s\n", pkg, err)
+			failed++
+			continue
+		}
+		row := health(p)
+		if row[0] != "ok" || (row[1] != "ok" && row[1] != "skipped") {
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", pkg, p.Depth, strings.Join(row, "\t"))
+	}
+	fmt.Fprintf(tw, "\n%d dependencies, %d failing\n", len(packages), failed)
+	return tw.Flush()
+}
+
+// health describes the build, test, vet, release, activity and import path
+// status of p, in the columns of writeHealth after the depth.
+func health(p gosrc.Package) []string {
+	if p.Download.Error != "" {
+		return []string{"download failed", "-", "-", "-", "-", "-"}
+	}
+	status := func(ok bool) string {
+		if ok {
+			return "ok"
+		}
+		return "FAIL"
+	}
+	row := []string{status(p.Build.Succeeded), "-", "-", "-", "-", "-"}
+	if p.Build.Succeeded {
+		row[1] = status(p.Test.Succeeded)
+		if p.Test.Skipped {
+			row[1] = "skipped"
+		}
+		row[2] = "ok"
+		if p.Vet.Errors > 0 {
+			row[2] = fmt.Sprintf("%d errors", p.Vet.Errors)
+		}
+	}
+
+	repo := p.Repository
+	if repo.Release.Name != "" {
+		row[3] = repo.Release.Name
+		if repo.Ahead > 0 {
+			row[3] += fmt.Sprintf(" +%d", repo.Ahead)
+		}
+	} else if repo.Revision.Id != "" {
+		row[3] = "none"
+	}
+	if a := repo.Activity; a.Commits > 0 {
+		row[4] = fmt.Sprintf("%d recent, last %s", a.Recent, a.Last.Format("2006-01-02"))
+	}
+	if c := p.ImportCheck; c.Checked {
+		row[5] = "ok"
+		if !c.OK() {
+			row[5] = strings.Join(c.Problems, "; ")
+		}
+	}
+	return row
+}
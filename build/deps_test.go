@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"github.com/kisielk/gosrc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadProject(t *testing.T) {
+	defer os.Setenv("GO111MODULE", os.Getenv("GO111MODULE"))
+	os.Setenv("GO111MODULE", "off")
+
+	dir, err := ioutil.TempDir("", "gosrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gopath := filepath.Join(dir, "gopath")
+	writeFiles(t, dir, map[string]string{
+		"proj/go.mod":                   "module example.com/proj\n\nrequire example.org/a v1.2.0\n\nrequire (\n\texample.org/c v0.0.0-20190102150405-abcdef012345 // indirect\n)\n",
+		"proj/main.go":                  "package main\nimport (\n\t\"fmt\"\n\t\"example.com/proj/sub\"\n\t\"example.org/a\"\n)\n",
+		"proj/sub/sub.go":               "package sub\nimport \"example.org/b\"\n",
+		"proj/sub/sub_test.go":          "package sub_test\nimport \"example.org/t\"\n",
+		"proj/vendor/v/v.go":            "package v\nimport \"example.org/vendored\"\n",
+		"gopath/src/example.org/a/a.go": "package a\nimport (\n\t\"strings\"\n\t\"example.org/c\"\n\t\"example.com/proj/sub\"\n)\n",
+		"gopath/src/example.org/c/c.go": "package c\nimport \"example.org/d\"\n",
+	})
+
+	p, err := loadProject(gopath, filepath.Join(dir, "proj"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ImportPath != "example.com/proj" {
+		t.Errorf("got import path %q", p.ImportPath)
+	}
+	want := map[string]int{
+		"example.org/a": 0,
+		"example.org/b": 0,
+		"example.org/t": 0,
+		"example.org/c": 1,
+		"example.org/d": 2,
+	}
+	if !reflect.DeepEqual(p.Deps, want) {
+		t.Errorf("got dependencies %v, want %v", p.Deps, want)
+	}
+	wantEntries := []gosrc.ListEntry{
+		{ImportPath: "example.org/a", Revision: "v1.2.0"},
+		{ImportPath: "example.org/b"},
+		{ImportPath: "example.org/c", Revision: "abcdef012345"},
+		{ImportPath: "example.org/t"},
+	}
+	if got := p.Entries(1); !reflect.DeepEqual(got, wantEntries) {
+		t.Errorf("got entries %+v, want %+v", got, wantEntries)
+	}
+	if got := p.Entries(-1); len(got) != len(want) {
+		t.Errorf("got %d entries without a depth limit, want %d", len(got), len(want))
+	}
+
+	if _, err := loadProject(gopath, filepath.Join(dir, "gopath")); err == nil {
+		t.Error("loaded a project outside a module and the GOPATH")
+	}
+}
+
+func TestVersionRevision(t *testing.T) {
+	var tests = []struct {
+		version, revision string
+	}{
+		{"v1.2.3", "v1.2.3"},
+		{"v2.0.0+incompatible", "v2.0.0"},
+		{"v0.0.0-20190102150405-abcdef012345", "abcdef012345"},
+		{"v1.2.4-0.20190102150405-abcdef012345", "abcdef012345"},
+		{"v1.3.0-beta.0.20190102150405-abcdef012345", "abcdef012345"},
+		{"v1.3.0-rc.1", "v1.3.0-rc.1"},
+	}
+	for _, test := range tests {
+		if got := versionRevision(test.version); got != test.revision {
+			t.Errorf("versionRevision(%q) = %q, want %q", test.version, got, test.revision)
+		}
+	}
+}
+
+func TestWriteHealth(t *testing.T) {
+	c := gosrc.NewMemoryCollection()
+	ok := gosrc.Package{ImportPath: "example.org/ok", Depth: 1}
+	ok.Build.Succeeded = true
+	ok.Test.Succeeded = true
+	ok.Repository.Release.Name = "v1.2.0"
+	ok.Repository.Ahead = 3
+	ok.ImportCheck = gosrc.ImportCheck{Checked: true, Problems: []string{"no go-source tag"}}
+	broken := gosrc.Package{ImportPath: "example.org/broken"}
+	broken.Download.Error = "not found"
+	for _, p := range []gosrc.Package{ok, broken} {
+		if err := c.Insert(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeHealth(&buf, c, []string{"example.org/ok", "example.org/missing", "example.org/broken"}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for i, want := range []string{
+		"PACKAGE",
+		"example.org/broken  0  download failed",
+		"example.org/missing",
+		"example.org/ok      1  ok  ok  ok  v1.2.0 +3  -  no go-source tag",
+		"",
+		"3 dependencies, 2 failing",
+	} {
+		if i >= len(lines) || !strings.HasPrefix(strings.Join(strings.Fields(lines[i]), "  "), strings.Join(strings.Fields(want), "  ")) {
+			t.Errorf("line %d: got %q, want %q", i, lines, want)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"github.com/kisielk/gosrc"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type downloadResult struct {
+	item     crawlItem
+	attempts int
+	err      error
+
+	// root and packages are the repository fetched for item and the
+	// packages in it, if item was the first of them to be downloaded.
+	root     string
+	packages []string
+}
+
+func startDownloader(gopath string, state *crawlState, downloadQueue chan crawlItem) chan downloadResult {
+	downloadRequests := make(chan crawlItem)
+
+	queue := newCrawlQueue(orderings[*order])
+	for p := range state.Done {
+		queue.MarkSeen(p)
+	}
+	for _, item := range state.Items() {
+		queue.Push(item)
+	}
+	go runQueue(queue, downloadQueue, downloadRequests)
+
+	results := make(chan downloadResult)
+	fetched := newRepoSet()
+	limiter := newHostLimiter(*hostDownloads, *hostDelay)
+	resolver := newResolver()
+	for i := 0; i < *numDownloaders; i++ {
+		go downloader(gopath, fetched, limiter, resolver, downloadRequests, results)
+	}
+	return results
+}
+
+// downloader fetches each repository at most once. Packages in a repository
+// that has already been fetched during this run are passed on once their
+// missing imports have been downloaded, without updating the repository.
+func downloader(gopath string, fetched *repoSet, limiter *hostLimiter, resolver *gosrc.Resolver, items chan crawlItem, results chan downloadResult) {
+	for item := range items {
+		attempts, root, err := fetch(gopath, fetched, limiter, resolver, item.path)
+		results <- downloadResult{item, attempts, err, root, repoPackages(gopath, root)}
+	}
+}
+
+// newResolver returns the resolver to use for planning downloads, or nil if
+// repositories aren't resolved ahead of time.
+func newResolver() *gosrc.Resolver {
+	if !*resolve {
+		return nil
+	}
+	return &gosrc.Resolver{Client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// fetch downloads or updates pkg. If its repository is already in fetched,
+// only the packages pkg imports that are missing from the GOPATH are
+// downloaded. It returns the number of download attempts made, and the
+// root of the repository if this call fetched it.
+//
+// If the repository can be resolved up front, packages from a repository
+// that is being downloaded wait for that download instead of starting
+// another, and downloads are rate limited by the host of the repository
+// rather than of the import path. Otherwise downloads wait for any other
+// with the same first three import path elements, which are the root of
+// the repository on most hosts.
+func fetch(gopath string, fetched *repoSet, limiter *hostLimiter, resolver *gosrc.Resolver, pkg string) (int, string, error) {
+	if root := fetched.Root(pkg); root != "" {
+		return fetchImports(gopath, limiter, pkg, root)
+	}
+	var rr gosrc.RepoRoot
+	if resolver != nil {
+		var err error
+		if rr, err = resolver.Resolve(pkg); err != nil {
+			// Leave it to go get, which will report a better error or
+			// knows something we don't.
+			log.Println(pkg, "not resolved:", err)
+		}
+	}
+	if rr.Root == "" {
+		key := pathPrefix(pkg, 3)
+		if !fetched.Claim(key) {
+			return fetchImports(gopath, limiter, pkg, key)
+		}
+		// The key only serializes downloads, so it is never marked as
+		// fetched; the repository is added by its actual root.
+		defer fetched.Done(key, false)
+		if root := fetched.Root(pkg); root != "" {
+			return fetchImports(gopath, limiter, pkg, root)
+		}
+		attempts, err := downloadWithRetry(gopath, limiter, importHost(pkg), pkg, true)
+		if err != nil {
+			return attempts, "", err
+		}
+		root := filepath.ToSlash(getRepository(gopath, pkg).Root)
+		fetched.Add(root)
+		return attempts, root, nil
+	}
+
+	if !fetched.Claim(rr.Root) {
+		return fetchImports(gopath, limiter, pkg, rr.Root)
+	}
+	host := importHost(pkg)
+	if u, err := url.Parse(rr.Repo); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	attempts, err := downloadWithRetry(gopath, limiter, host, pkg, true)
+	fetched.Done(rr.Root, err == nil)
+	if err != nil {
+		return attempts, "", err
+	}
+	return attempts, rr.Root, nil
+}
+
+// fetchImports downloads the packages imported by pkg that are missing from
+// the GOPATH, leaving the repository at root, which holds pkg and has
+// already been fetched, as it is.
+func fetchImports(gopath string, limiter *hostLimiter, pkg, root string) (int, string, error) {
+	log.Println(pkg, "already downloaded with", root)
+	attempts, err := downloadWithRetry(gopath, limiter, importHost(pkg), pkg, false)
+	return attempts, "", err
+}
+
+// repoPackages returns the packages in the repository at root, relative to
+// GOPATH/src.
+func repoPackages(gopath, root string) []string {
+	if root == "" {
+		return nil
+	}
+	dir := filepath.Join(gopath, "src", filepath.FromSlash(root))
+	packages, err := gosrc.TreeSource{Dir: dir, Prefix: root}.Packages()
+	if err != nil {
+		log.Println(root, "failed to list packages:", err)
+	}
+	return packages
+}
+
+// pathPrefix returns the first n elements of an import path.
+func pathPrefix(pkg string, n int) string {
+	elems := strings.SplitN(pkg, "/", n+1)
+	if len(elems) > n {
+		elems = elems[:n]
+	}
+	return strings.Join(elems, "/")
+}
+
+// downloadWithRetry downloads pkg, retrying transient failures with
+// exponential backoff. Downloads are limited per host. It returns the
+// number of attempts made.
+func downloadWithRetry(gopath string, limiter *hostLimiter, host, pkg string, update bool) (int, error) {
+	delay := *retryDelay
+	for attempt := 1; ; attempt++ {
+		limiter.Acquire(host)
+		log.Println(pkg, "downloading")
+		// Downloads share the GOPATH with each other, but not with snapshots.
+		gopathLock.RLock()
+		err := download(gopath, pkg, update)
+		gopathLock.RUnlock()
+		limiter.Release(host)
+
+		if err == nil || attempt > *retries || !isTransient(err) {
+			return attempt, err
+		}
+		log.Println(pkg, "download failed, retrying in", delay, "-", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// downloadError is returned by download when `go get` fails.
+type downloadError struct {
+	err       error
+	stderr    string
+	transient bool
+}
+
+func (e *downloadError) Error() string {
+	if e.stderr == "" {
+		return e.err.Error()
+	}
+	return e.err.Error() + ": " + e.stderr
+}
+
+func isTransient(err error) bool {
+	e, ok := err.(*downloadError)
+	return ok && e.transient
+}
+
+func download(gopath, pkg string, update bool) error {
+	var stderr bytes.Buffer
+	args := []string{"get", "-d"}
+	if update {
+		args = append(args, "-u")
+	}
+	cmd := exec.Command("go", append(args, pkg)...)
+	cmd.Env = makeEnv(gopath)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	e := &downloadError{err: err, stderr: strings.TrimSpace(stderr.String())}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		ws := exitErr.Sys().(syscall.WaitStatus)
+		// go get exits with status 1 for every failure, so a signal is the
+		// only thing the exit status tells us; otherwise go by the message.
+		e.transient = ws.Signaled() || transientFailure(e.stderr)
+	}
+	return e
+}
+
+// transientMessages are fragments of go get and VCS error output that
+// indicate a failure which may succeed if retried.
+var transientMessages = []string{
+	"timeout",
+	"timed out",
+	"temporary failure",
+	"try again",
+	"connection reset",
+	"connection refused",
+	"connection closed",
+	"broken pipe",
+	"early eof",
+	"unexpected eof",
+	"tls handshake",
+	"the remote end hung up",
+	"rpc failed",
+	"too many requests",
+	"internal server error",
+	"bad gateway",
+	"service unavailable",
+	"gateway timeout",
+}
+
+// transientFailure reports whether the stderr output of a failed download
+// looks like a temporary network or server problem.
+func transientFailure(stderr string) bool {
+	s := strings.ToLower(stderr)
+	for _, m := range transientMessages {
+		if strings.Contains(s, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// insertDownloadFailure records a failed download in the collection. Results
+// from earlier runs are replaced, since they no longer describe the code
+// that can be fetched for the package.
+func insertDownloadFailure(collection gosrc.Collection, r downloadResult) error {
+	return collection.Insert(gosrc.Package{
+		ImportPath: r.item.path,
+		Date:       time.Now(),
+		GoVersion:  goVersion,
+		Depth:      r.item.depth,
+		Listed:     r.item.listed(),
+		Group:      r.item.options.Group,
+		Pinned:     r.item.options.Revision,
+		Download: gosrc.Download{
+			Attempts:  r.attempts,
+			Error:     r.err.Error(),
+			Transient: isTransient(r.err),
+		},
+	})
+}
+
+// importHost returns the host portion of an import path.
+func importHost(pkg string) string {
+	if i := strings.Index(pkg, "/"); i >= 0 {
+		return pkg[:i]
+	}
+	return pkg
+}
+
+// repoSet is a set of repository roots, relative to GOPATH/src, that have
+// been downloaded, and of those that are being downloaded.
+type repoSet struct {
+	mu       sync.Mutex
+	roots    map[string]bool
+	inflight map[string]chan struct{} // closed when the download is done
+}
+
+func newRepoSet() *repoSet {
+	return &repoSet{roots: make(map[string]bool), inflight: make(map[string]chan struct{})}
+}
+
+// Claim reports whether the caller should download the repository at root,
+// in which case it must call Done when finished. If root is being downloaded
+// by another caller, Claim waits for it, and returns false if it succeeded.
+func (s *repoSet) Claim(root string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.roots[root] {
+			return false
+		}
+		done, ok := s.inflight[root]
+		if !ok {
+			s.inflight[root] = make(chan struct{})
+			return true
+		}
+		s.mu.Unlock()
+		<-done
+		s.mu.Lock()
+	}
+}
+
+// Done ends a download of root started with Claim. A failed download
+// leaves root for the next caller of Claim to try.
+func (s *repoSet) Done(root string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ok {
+		s.roots[root] = true
+	}
+	close(s.inflight[root])
+	delete(s.inflight, root)
+}
+
+func (s *repoSet) Add(root string) {
+	if root == "" {
+		return
+	}
+	s.mu.Lock()
+	s.roots[root] = true
+	s.mu.Unlock()
+}
+
+// Root returns the root of the repository in the set that contains pkg,
+// or "" if there is none.
+func (s *repoSet) Root(pkg string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := pkg; p != "."; p = path.Dir(p) {
+		if s.roots[p] {
+			return p
+		}
+	}
+	return ""
+}
+
+// hostLimiter caps the number of concurrent downloads from each host and
+// spaces out the start of consecutive downloads from the same host.
+type hostLimiter struct {
+	max   int
+	delay time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	slots chan struct{}
+	next  time.Time // earliest time the next download may start
+}
+
+func newHostLimiter(max int, delay time.Duration) *hostLimiter {
+	if max < 1 {
+		max = 1
+	}
+	return &hostLimiter{max: max, delay: delay, hosts: make(map[string]*hostState)}
+}
+
+func (l *hostLimiter) state(host string) *hostState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostState{slots: make(chan struct{}, l.max)}
+		l.hosts[host] = h
+	}
+	return h
+}
+
+// Acquire blocks until a download from host may start.
+func (l *hostLimiter) Acquire(host string) {
+	h := l.state(host)
+	h.slots <- struct{}{}
+
+	l.mu.Lock()
+	now := time.Now()
+	start := now
+	if h.next.After(now) {
+		start = h.next
+	}
+	h.next = start.Add(l.delay)
+	l.mu.Unlock()
+
+	time.Sleep(start.Sub(now))
+}
+
+// Release marks a download from host as finished.
+func (l *hostLimiter) Release(host string) {
+	<-l.state(host).slots
+}
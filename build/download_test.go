@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"github.com/kisielk/gosrc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTransientFailure(t *testing.T) {
+	var tests = []struct {
+		stderr    string
+		transient bool
+	}{
+		{"", false},
+		{`package example.com/nope: unrecognized import path "example.com/nope"`, false},
+		{`# cd .; git clone https://github.com/foo/bar /tmp/gopath/src/github.com/foo/bar
+Cloning into '/tmp/gopath/src/github.com/foo/bar'...
+fatal: repository 'https://github.com/foo/bar/' not found
+package github.com/foo/bar: exit status 128`, false},
+		{`package example.com/pkg: unrecognized import path "example.com/pkg" (https fetch: Get https://example.com/pkg?go-get=1: dial tcp 93.184.216.34:443: i/o timeout)`, true},
+		{`fatal: unable to access 'https://github.com/foo/bar/': Could not resolve host: github.com (Temporary failure in name resolution)`, true},
+		{`error: RPC failed; curl 56 GnuTLS recv error (-54): Error in the pull function.
+fatal: The remote end hung up unexpectedly
+fatal: early EOF`, true},
+		{`abort: HTTP Error 503: Service Unavailable`, true},
+	}
+	for _, test := range tests {
+		if got := transientFailure(test.stderr); got != test.transient {
+			t.Errorf("transientFailure(%q) = %v, want %v", test.stderr, got, test.transient)
+		}
+	}
+}
+
+func TestRepoSetClaim(t *testing.T) {
+	s := newRepoSet()
+	if !s.Claim("example.com/repo") {
+		t.Fatal("first Claim should succeed")
+	}
+
+	// A second claim waits for the first download, and retries it if it failed.
+	claimed := make(chan bool)
+	go func() { claimed <- s.Claim("example.com/repo") }()
+	select {
+	case <-claimed:
+		t.Fatal("Claim returned while the repository was being downloaded")
+	case <-time.After(10 * time.Millisecond):
+	}
+	s.Done("example.com/repo", false)
+	if !<-claimed {
+		t.Fatal("Claim after a failed download should succeed")
+	}
+
+	go func() { claimed <- s.Claim("example.com/repo") }()
+	s.Done("example.com/repo", true)
+	if <-claimed {
+		t.Error("Claim after a successful download should fail")
+	}
+	if root := s.Root("example.com/repo/sub"); root != "example.com/repo" {
+		t.Errorf("Root: got %q, want example.com/repo", root)
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	l := newHostLimiter(2, delay)
+
+	// Consecutive downloads from a host are spaced out by the delay.
+	start := time.Now()
+	l.Acquire("a.example.com")
+	l.Acquire("a.example.com")
+	if d := time.Since(start); d < delay {
+		t.Errorf("second download started after %v, want at least %v", d, delay)
+	}
+
+	// Only two downloads from a host run at once, but other hosts aren't held up.
+	acquired := make(chan bool)
+	go func() {
+		l.Acquire("a.example.com")
+		acquired <- true
+	}()
+	start = time.Now()
+	l.Acquire("b.example.com")
+	if d := time.Since(start); d >= delay {
+		t.Errorf("download from another host waited %v", d)
+	}
+	select {
+	case <-acquired:
+		t.Fatal("third download from a host started while two were running")
+	case <-time.After(5 * delay):
+	}
+	l.Release("a.example.com")
+	<-acquired
+}
+
+func TestPathPrefix(t *testing.T) {
+	var tests = []struct {
+		pkg, prefix string
+	}{
+		{"github.com/user/repo/sub/pkg", "github.com/user/repo"},
+		{"github.com/user/repo", "github.com/user/repo"},
+		{"example.com/pkg", "example.com/pkg"},
+	}
+	for _, test := range tests {
+		if got := pathPrefix(test.pkg, 3); got != test.prefix {
+			t.Errorf("pathPrefix(%q, 3) = %q, want %q", test.pkg, got, test.prefix)
+		}
+	}
+}
+
+func TestRepoPackages(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "gosrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	writeFiles(t, filepath.Join(gopath, "src"), map[string]string{
+		"example.com/repo/repo.go":           "package repo\n",
+		"example.com/repo/a/a.go":            "package a\n",
+		"example.com/repo/a/testdata/t/t.go": "package t\n",
+		"example.com/repo/vendor/v/v.go":     "package v\n",
+		"example.com/other/other.go":         "package other\n",
+	})
+
+	want := []string{"example.com/repo", "example.com/repo/a"}
+	if got := repoPackages(gopath, "example.com/repo"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := repoPackages(gopath, ""); got != nil {
+		t.Errorf("without a root: got %q", got)
+	}
+}
+
+func TestInsertDownloadFailure(t *testing.T) {
+	c := gosrc.NewMemoryCollection()
+	old := gosrc.Package{Downloaded: true, ImportPath: "example.com/a", Depth: 3}
+	old.Build.Succeeded = true
+	old.Test.Succeeded = true
+	if err := c.Insert(old); err != nil {
+		t.Fatal(err)
+	}
+
+	r := downloadResult{
+		item:     crawlItem{path: "example.com/a", depth: 1, options: gosrc.ListEntry{ImportPath: "example.com/a", Group: "team", Revision: "v1.0.0"}},
+		attempts: 2,
+		err:      &downloadError{err: errors.New("exit status 1"), transient: true},
+	}
+	if err := insertDownloadFailure(c, r); err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.Get("example.com/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Downloaded || p.Build.Succeeded || p.Test.Succeeded {
+		t.Errorf("results of an earlier build survived a failed download: %+v", p)
+	}
+	if p.Depth != 1 || !p.Listed || p.Group != "team" || p.Pinned != "v1.0.0" {
+		t.Errorf("got depth %d, listed %v, group %q and pin %q, want 1, true, team and v1.0.0", p.Depth, p.Listed, p.Group, p.Pinned)
+	}
+	want := gosrc.Download{Attempts: 2, Error: "exit status 1", Transient: true}
+	if p.Download != want {
+		t.Errorf("got download %+v, want %+v", p.Download, want)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/kisielk/gosrc"
+	"strings"
+)
+
+// expansions decide whether an import of pkg is followed by the crawl.
+var expansions = map[string]func(pkg gosrc.Package, imp string) bool{
+	// all follows every import.
+	"all": func(pkg gosrc.Package, imp string) bool {
+		return true
+	},
+	// none records imports without crawling them.
+	"none": func(pkg gosrc.Package, imp string) bool {
+		return false
+	},
+	// repo follows imports within the importing package's repository.
+	"repo": func(pkg gosrc.Package, imp string) bool {
+		root := pkg.Repository.Root
+		return root != "" && (imp == root || strings.HasPrefix(imp, root+"/"))
+	},
+	// host follows imports from the importing package's host.
+	"host": func(pkg gosrc.Package, imp string) bool {
+		return importHost(imp) == importHost(pkg.ImportPath)
+	},
+}
+
+// follow returns the imports of pkg, found at depth, to crawl next.
+func follow(pkg gosrc.Package, depth int) []string {
+	if *maxDepth >= 0 && depth >= *maxDepth {
+		return nil
+	}
+	expand := expansions[*expansion]
+	var imports []string
+	for _, imp := range pkg.BuildInfo.Imports {
+		if expand(pkg, imp) {
+			imports = append(imports, imp)
+		}
+	}
+	return imports
+}
+
+// followRepo returns the packages, other than pkg found at depth, in the
+// repository at root that was fetched for pkg, to crawl next. They are
+// reached through the repository rather than an import, so they are at the
+// same depth as pkg, but only pkg may be from the package list. With -deps
+// only the project's dependencies and their imports are crawled, so none
+// are followed.
+func followRepo(pkg, root string, packages []string) []string {
+	if *depsDir != "" {
+		return nil
+	}
+	expand := expansions[*expansion]
+	importer := gosrc.Package{ImportPath: pkg, Repository: gosrc.Repository{Root: root}}
+	var follow []string
+	for _, p := range packages {
+		if p != pkg && expand(importer, p) {
+			follow = append(follow, p)
+		}
+	}
+	return follow
+}
+
+// lowerDepth records that pkg, which has been crawled, was reached by a
+// shorter path at depth. It returns the imports of pkg to follow again, so
+// that their depths are lowered too, and any that were beyond -max-depth
+// are crawled.
+func lowerDepth(collection gosrc.Collection, pkg string, depth int) ([]string, error) {
+	p, err := collection.Get(pkg)
+	if err != nil {
+		return nil, err
+	}
+	p.Depth = depth
+	if err := collection.Insert(p); err != nil {
+		return nil, err
+	}
+	if p.Download.Error != "" {
+		return nil, nil
+	}
+	return follow(p, depth), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/kisielk/gosrc"
+	"reflect"
+	"testing"
+)
+
+func TestFollow(t *testing.T) {
+	defer func(depth int, mode string) { *maxDepth, *expansion = depth, mode }(*maxDepth, *expansion)
+
+	pkg := gosrc.Package{
+		ImportPath: "github.com/kisielk/gosrc/build",
+		Repository: gosrc.Repository{Root: "github.com/kisielk/gosrc"},
+		BuildInfo: gosrc.BuildInfo{Imports: []string{
+			"github.com/kisielk/gosrc",
+			"github.com/kisielk/errcheck/lib",
+			"labix.org/v2/mgo",
+		}},
+	}
+	var tests = []struct {
+		mode     string
+		maxDepth int
+		depth    int
+		want     []string
+	}{
+		{"all", -1, 5, pkg.BuildInfo.Imports},
+		{"all", 2, 1, pkg.BuildInfo.Imports},
+		{"all", 2, 2, nil},
+		{"all", 0, 0, nil},
+		{"none", -1, 0, nil},
+		{"repo", -1, 0, []string{"github.com/kisielk/gosrc"}},
+		{"host", -1, 0, []string{"github.com/kisielk/gosrc", "github.com/kisielk/errcheck/lib"}},
+	}
+	for _, test := range tests {
+		*expansion, *maxDepth = test.mode, test.maxDepth
+		if got := follow(pkg, test.depth); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s, max depth %d, at depth %d: got %q, want %q", test.mode, test.maxDepth, test.depth, got, test.want)
+		}
+	}
+
+	// Without a repository root, repo mode follows nothing.
+	*expansion, *maxDepth = "repo", -1
+	pkg.Repository.Root = ""
+	if got := follow(pkg, 0); got != nil {
+		t.Errorf("repo mode without a root: got %q", got)
+	}
+}
+
+func TestFollowRepo(t *testing.T) {
+	defer func(mode string) { *expansion = mode }(*expansion)
+
+	packages := []string{"example.com/repo", "example.com/repo/a", "example.com/repo/b"}
+	*expansion = "all"
+	want := []string{"example.com/repo", "example.com/repo/b"}
+	if got := followRepo("example.com/repo/a", "example.com/repo", packages); !reflect.DeepEqual(got, want) {
+		t.Errorf("all: got %q, want %q", got, want)
+	}
+	*expansion = "none"
+	if got := followRepo("example.com/repo/a", "example.com/repo", packages); got != nil {
+		t.Errorf("none: got %q", got)
+	}
+
+	defer func(dir string) { *depsDir = dir }(*depsDir)
+	*expansion, *depsDir = "all", "proj"
+	if got := followRepo("example.com/repo/a", "example.com/repo", packages); got != nil {
+		t.Errorf("-deps: got %q", got)
+	}
+}
+
+func TestLowerDepth(t *testing.T) {
+	defer func(depth int, mode string) { *maxDepth, *expansion = depth, mode }(*maxDepth, *expansion)
+	*maxDepth, *expansion = 2, "all"
+
+	c := gosrc.NewMemoryCollection()
+	pkg := gosrc.Package{ImportPath: "example.com/a", Depth: 2}
+	pkg.BuildInfo.Imports = []string{"example.com/b"}
+	if err := c.Insert(pkg); err != nil {
+		t.Fatal(err)
+	}
+	if got := follow(pkg, pkg.Depth); got != nil {
+		t.Fatalf("follow at the depth limit: got %q", got)
+	}
+
+	// Reached by a shorter path, the package's imports are within the limit.
+	got, err := lowerDepth(c, "example.com/a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := pkg.BuildInfo.Imports; !reflect.DeepEqual(got, want) {
+		t.Errorf("got imports %q, want %q", got, want)
+	}
+	if stored, _ := c.Get("example.com/a"); stored.Depth != 1 {
+		t.Errorf("got stored depth %d, want 1", stored.Depth)
+	}
+
+	if _, err := lowerDepth(c, "example.com/missing", 0); err != gosrc.ErrNotFound {
+		t.Errorf("missing package: got error %v", err)
+	}
+}
@@ -0,0 +1,752 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitRepo reads a git repository directly from its .git directory.
+type gitRepo struct {
+	workTree  string // top level of the working tree
+	gitDir    string // directory holding HEAD
+	commonDir string // directory holding objects, refs and config
+
+	packs []*gitPack // loaded by loadPacks
+}
+
+// openGitRepo finds the git repository containing dir.
+func openGitRepo(dir string) (*gitRepo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		gitDir, err := findGitDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		if gitDir != "" {
+			r := &gitRepo{workTree: dir, gitDir: gitDir, commonDir: gitDir}
+			if b, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+				r.commonDir = resolvePath(gitDir, strings.TrimSpace(string(b)))
+			}
+			return r, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, errNotRepository
+		}
+		dir = parent
+	}
+}
+
+// findGitDir returns the git directory for a working tree rooted at dir,
+// or "" if dir isn't the root of a working tree.
+func findGitDir(dir string) (string, error) {
+	path := filepath.Join(dir, ".git")
+	fi, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		return "", nil
+	case err != nil:
+		return "", err
+	case fi.IsDir():
+		return path, nil
+	}
+
+	// A .git file points at the git directory of a worktree or submodule.
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(s, "gitdir: ") {
+		return "", fmt.Errorf("%s: unrecognized .git file", path)
+	}
+	return resolvePath(dir, strings.TrimPrefix(s, "gitdir: ")), nil
+}
+
+func resolvePath(base, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(base, path)
+}
+
+// Head returns the hash of the commit checked out in the working tree.
+func (r *gitRepo) Head() (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(r.gitDir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSpace(string(b))
+	if strings.HasPrefix(s, "ref: ") {
+		return r.Ref(strings.TrimPrefix(s, "ref: "))
+	}
+	return checkHash(s)
+}
+
+// HeadRef returns the name of the ref HEAD points at, or "" if HEAD is
+// detached.
+func (r *gitRepo) HeadRef() (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(r.gitDir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSpace(string(b))
+	if strings.HasPrefix(s, "ref: ") {
+		return strings.TrimPrefix(s, "ref: "), nil
+	}
+	return "", nil
+}
+
+// Ref resolves a ref name such as refs/heads/master to a hash.
+func (r *gitRepo) Ref(name string) (string, error) {
+	for i := 0; i < 10; i++ {
+		b, err := ioutil.ReadFile(filepath.Join(r.commonDir, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			refs, err := r.packedRefs()
+			if err != nil {
+				return "", err
+			}
+			if hash, ok := refs[name]; ok {
+				return hash, nil
+			}
+			return "", fmt.Errorf("ref %s not found", name)
+		}
+		if err != nil {
+			return "", err
+		}
+		s := strings.TrimSpace(string(b))
+		if !strings.HasPrefix(s, "ref: ") {
+			return checkHash(s)
+		}
+		name = strings.TrimPrefix(s, "ref: ")
+	}
+	return "", fmt.Errorf("ref %s: too many levels of symbolic refs", name)
+}
+
+// Refs returns the hashes of all refs whose names start with prefix.
+func (r *gitRepo) Refs(prefix string) (map[string]string, error) {
+	refs, err := r.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(r.commonDir, filepath.FromSlash(prefix))
+	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(r.commonDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		hash, err := r.Ref(name)
+		if err != nil {
+			return err
+		}
+		refs[name] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name := range refs {
+		if !strings.HasPrefix(name, prefix) {
+			delete(refs, name)
+		}
+	}
+	return refs, nil
+}
+
+// packedRefs reads the packed-refs file.
+func (r *gitRepo) packedRefs() (map[string]string, error) {
+	refs := make(map[string]string)
+	f, err := os.Open(filepath.Join(r.commonDir, "packed-refs"))
+	if os.IsNotExist(err) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l := scanner.Text()
+		if l == "" || l[0] == '#' || l[0] == '^' {
+			// Comments and peeled tags.
+			continue
+		}
+		parts := strings.SplitN(l, " ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed packed-refs line: %q", l)
+		}
+		refs[parts[1]] = parts[0]
+	}
+	return refs, scanner.Err()
+}
+
+func checkHash(s string) (string, error) {
+	if len(s) != 40 {
+		return "", fmt.Errorf("invalid object name %q", s)
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return "", fmt.Errorf("invalid object name %q", s)
+	}
+	return s, nil
+}
+
+// Object types, as numbered in pack files.
+const (
+	gitCommit   = 1
+	gitTree     = 2
+	gitBlob     = 3
+	gitTag      = 4
+	gitOfsDelta = 6
+	gitRefDelta = 7
+)
+
+var gitTypeNames = map[string]int{
+	"commit": gitCommit,
+	"tree":   gitTree,
+	"blob":   gitBlob,
+	"tag":    gitTag,
+}
+
+// Object returns the type and contents of the object with the given hash.
+func (r *gitRepo) Object(hash string) (int, []byte, error) {
+	if _, err := checkHash(hash); err != nil {
+		return 0, nil, err
+	}
+	typ, data, err := r.looseObject(hash)
+	if !os.IsNotExist(err) {
+		return typ, data, err
+	}
+	if err := r.loadPacks(); err != nil {
+		return 0, nil, err
+	}
+	for _, p := range r.packs {
+		if offset, ok := p.find(hash); ok {
+			return p.object(r, offset)
+		}
+	}
+	return 0, nil, fmt.Errorf("object %s not found", hash)
+}
+
+func (r *gitRepo) looseObject(hash string) (int, []byte, error) {
+	f, err := os.Open(filepath.Join(r.commonDir, "objects", hash[:2], hash[2:]))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	z, err := zlib.NewReader(f)
+	if err != nil {
+		return 0, nil, fmt.Errorf("object %s: %s", hash, err)
+	}
+	defer z.Close()
+	b, err := ioutil.ReadAll(z)
+	if err != nil {
+		return 0, nil, fmt.Errorf("object %s: %s", hash, err)
+	}
+
+	// "<type> <size>\x00<data>"
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return 0, nil, fmt.Errorf("object %s: malformed header", hash)
+	}
+	header := strings.SplitN(string(b[:i]), " ", 2)
+	typ, ok := gitTypeNames[header[0]]
+	if !ok || len(header) != 2 {
+		return 0, nil, fmt.Errorf("object %s: malformed header %q", hash, b[:i])
+	}
+	data := b[i+1:]
+	if size, err := strconv.Atoi(header[1]); err != nil || size != len(data) {
+		return 0, nil, fmt.Errorf("object %s: size mismatch", hash)
+	}
+	return typ, data, nil
+}
+
+// gitPack is a pack file and its index.
+type gitPack struct {
+	path    string
+	hashes  []byte   // sorted 20 byte object names
+	offsets []uint64 // pack offsets, in the same order as hashes
+	fanout  [256]uint32
+}
+
+func (r *gitRepo) loadPacks() error {
+	if r.packs != nil {
+		return nil
+	}
+	idxs, err := filepath.Glob(filepath.Join(r.commonDir, "objects", "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(idxs)
+	r.packs = []*gitPack{}
+	for _, idx := range idxs {
+		p, err := readPackIndex(idx)
+		if err != nil {
+			return err
+		}
+		r.packs = append(r.packs, p)
+	}
+	return nil
+}
+
+func readPackIndex(path string) (*gitPack, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &gitPack{path: strings.TrimSuffix(path, ".idx") + ".pack"}
+	corrupt := fmt.Errorf("%s: corrupt pack index", path)
+
+	version := 1
+	if len(b) >= 8 && bytes.Equal(b[:4], []byte("\xfftOc")) {
+		version = int(binary.BigEndian.Uint32(b[4:8]))
+		if version != 2 {
+			return nil, fmt.Errorf("%s: unsupported pack index version %d", path, version)
+		}
+		b = b[8:]
+	}
+	if len(b) < 256*4 {
+		return nil, corrupt
+	}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(b[i*4:])
+	}
+	b = b[256*4:]
+	n := int(p.fanout[255])
+
+	if version == 1 {
+		// n entries of a 4 byte offset followed by a 20 byte name.
+		if len(b) < n*24 {
+			return nil, corrupt
+		}
+		p.hashes = make([]byte, 0, n*20)
+		p.offsets = make([]uint64, n)
+		for i := 0; i < n; i++ {
+			e := b[i*24:]
+			p.offsets[i] = uint64(binary.BigEndian.Uint32(e))
+			p.hashes = append(p.hashes, e[4:24]...)
+		}
+		return p, nil
+	}
+
+	// n names, n CRCs, n 4 byte offsets, then 8 byte offsets for large packs.
+	if len(b) < n*28 {
+		return nil, corrupt
+	}
+	p.hashes = b[:n*20]
+	small := b[n*24 : n*28]
+	large := b[n*28:]
+	p.offsets = make([]uint64, n)
+	for i := 0; i < n; i++ {
+		off := binary.BigEndian.Uint32(small[i*4:])
+		if off&0x80000000 == 0 {
+			p.offsets[i] = uint64(off)
+			continue
+		}
+		j := int(off &^ 0x80000000)
+		if len(large) < (j+1)*8 {
+			return nil, corrupt
+		}
+		p.offsets[i] = binary.BigEndian.Uint64(large[j*8:])
+	}
+	return p, nil
+}
+
+// find returns the offset in the pack of the object with the given hash.
+func (p *gitPack) find(hash string) (uint64, bool) {
+	name, err := hex.DecodeString(hash)
+	if err != nil || len(name) != 20 {
+		return 0, false
+	}
+	lo := 0
+	if name[0] > 0 {
+		lo = int(p.fanout[name[0]-1])
+	}
+	hi := int(p.fanout[name[0]])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.hashes[(lo+i)*20:(lo+i+1)*20], name) >= 0
+	})
+	if i < hi && bytes.Equal(p.hashes[i*20:(i+1)*20], name) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// object reads the object at offset, resolving deltas.
+func (p *gitPack) object(r *gitRepo, offset uint64) (int, []byte, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	return p.readAt(r, f, offset, 0)
+}
+
+// maxDeltaChain bounds the length of delta chains, to guard against cycles
+// in corrupt packs.
+const maxDeltaChain = 10000
+
+func (p *gitPack) readAt(r *gitRepo, f *os.File, offset uint64, depth int) (int, []byte, error) {
+	if depth > maxDeltaChain {
+		return 0, nil, fmt.Errorf("%s: delta chain too long", p.path)
+	}
+	var header [32]byte
+	n, err := f.ReadAt(header[:], int64(offset))
+	if err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	h := header[:n]
+	corrupt := fmt.Errorf("%s: corrupt object at offset %d", p.path, offset)
+
+	// Type and size: 3 type bits and 4 size bits, then 7 size bits per byte.
+	if len(h) == 0 {
+		return 0, nil, corrupt
+	}
+	typ := int(h[0]>>4) & 7
+	size := uint64(h[0] & 0x0f)
+	shift := uint(4)
+	i := 0
+	for h[i]&0x80 != 0 {
+		i++
+		if i >= len(h) {
+			return 0, nil, corrupt
+		}
+		size |= uint64(h[i]&0x7f) << shift
+		shift += 7
+	}
+	i++
+
+	var base struct {
+		typ  int
+		data []byte
+	}
+	switch typ {
+	case gitOfsDelta:
+		// Big-endian base-128 with an offset of one added per extra byte.
+		if i >= len(h) {
+			return 0, nil, corrupt
+		}
+		c := h[i]
+		rel := uint64(c & 0x7f)
+		for c&0x80 != 0 {
+			i++
+			if i >= len(h) {
+				return 0, nil, corrupt
+			}
+			c = h[i]
+			rel = (rel+1)<<7 | uint64(c&0x7f)
+		}
+		i++
+		if rel > offset {
+			return 0, nil, corrupt
+		}
+		base.typ, base.data, err = p.readAt(r, f, offset-rel, depth+1)
+	case gitRefDelta:
+		if i+20 > len(h) {
+			return 0, nil, corrupt
+		}
+		base.typ, base.data, err = r.Object(hex.EncodeToString(h[i : i+20]))
+		i += 20
+	case gitCommit, gitTree, gitBlob, gitTag:
+	default:
+		return 0, nil, corrupt
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	z, err := zlib.NewReader(io.NewSectionReader(f, int64(offset)+int64(i), 1<<62))
+	if err != nil {
+		return 0, nil, corrupt
+	}
+	defer z.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(z, data); err != nil {
+		return 0, nil, corrupt
+	}
+
+	if base.data == nil {
+		return typ, data, nil
+	}
+	data, err = applyDelta(base.data, data)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: object at offset %d: %s", p.path, offset, err)
+	}
+	return base.typ, data, nil
+}
+
+var errBadDelta = errors.New("malformed delta")
+
+// applyDelta applies a git delta to base.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	varint := func() (uint64, error) {
+		var v uint64
+		for shift := uint(0); len(delta) > 0; shift += 7 {
+			c := delta[0]
+			delta = delta[1:]
+			v |= uint64(c&0x7f) << shift
+			if c&0x80 == 0 {
+				return v, nil
+			}
+		}
+		return 0, errBadDelta
+	}
+	srcSize, err := varint()
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != uint64(len(base)) {
+		return nil, errBadDelta
+	}
+	dstSize, err := varint()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// Copy from base: bits 0-3 select offset bytes, bits 4-6 size bytes.
+			var off, n uint64
+			for j := uint(0); j < 7; j++ {
+				if op&(1<<j) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errBadDelta
+				}
+				if j < 4 {
+					off |= uint64(delta[0]) << (8 * j)
+				} else {
+					n |= uint64(delta[0]) << (8 * (j - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if off+n > uint64(len(base)) {
+				return nil, errBadDelta
+			}
+			out = append(out, base[off:off+n]...)
+		case op != 0:
+			// Insert the next op bytes.
+			if int(op) > len(delta) {
+				return nil, errBadDelta
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errBadDelta
+		}
+	}
+	if uint64(len(out)) != dstSize {
+		return nil, errBadDelta
+	}
+	return out, nil
+}
+
+// gitCommitInfo holds the parts of a commit object that gosrc records.
+type gitCommitInfo struct {
+	Hash    string
+	Parents []string
+	Author  string // "Name <email>"
+	Date    time.Time
+}
+
+// Commit reads the commit with the given hash.
+func (r *gitRepo) Commit(hash string) (gitCommitInfo, error) {
+	c := gitCommitInfo{Hash: hash}
+	typ, data, err := r.Object(hash)
+	if err != nil {
+		return c, err
+	}
+	if typ != gitCommit {
+		return c, fmt.Errorf("object %s is not a commit", hash)
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		if l == "" {
+			// End of headers.
+			break
+		}
+		parts := strings.SplitN(l, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "parent":
+			c.Parents = append(c.Parents, parts[1])
+		case "author":
+			c.Author, c.Date, err = parseGitSignature(parts[1])
+			if err != nil {
+				return c, fmt.Errorf("commit %s: %s", hash, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// Peel follows tag objects from hash to the commit they point at. It also
+// returns the date of the first tag object, if hash is one.
+func (r *gitRepo) Peel(hash string) (string, time.Time, error) {
+	var date time.Time
+	for i := 0; i < 10; i++ {
+		typ, data, err := r.Object(hash)
+		if err != nil {
+			return "", date, err
+		}
+		if typ != gitTag {
+			return hash, date, nil
+		}
+		var target string
+		for _, l := range strings.Split(string(data), "\n") {
+			if l == "" {
+				break
+			}
+			parts := strings.SplitN(l, " ", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			switch parts[0] {
+			case "object":
+				target = parts[1]
+			case "tagger":
+				if date.IsZero() {
+					_, date, _ = parseGitSignature(parts[1])
+				}
+			}
+		}
+		if target == "" {
+			return "", date, fmt.Errorf("tag %s has no object", hash)
+		}
+		hash = target
+	}
+	return "", date, fmt.Errorf("tag %s: too many levels of tags", hash)
+}
+
+// Walk calls fn for every commit reachable from hash that isn't in skip,
+// and returns the hashes of the commits visited.
+func (r *gitRepo) Walk(hash string, skip map[string]bool, fn func(gitCommitInfo)) (map[string]bool, error) {
+	seen := make(map[string]bool)
+	stack := []string{hash}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[h] || skip[h] {
+			continue
+		}
+		seen[h] = true
+		c, err := r.Commit(h)
+		if err != nil {
+			return nil, err
+		}
+		if fn != nil {
+			fn(c)
+		}
+		stack = append(stack, c.Parents...)
+	}
+	return seen, nil
+}
+
+// parseGitSignature parses "Name <email> 1401143445 -0700".
+func parseGitSignature(s string) (string, time.Time, error) {
+	i := strings.LastIndex(s, ">")
+	if i < 0 {
+		return "", time.Time{}, fmt.Errorf("malformed signature %q", s)
+	}
+	who := s[:i+1]
+	fields := strings.Fields(s[i+1:])
+	if len(fields) != 2 || len(fields[1]) != 5 {
+		return "", time.Time{}, fmt.Errorf("malformed signature %q", s)
+	}
+	secs, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("malformed signature %q", s)
+	}
+	tz := fields[1]
+	hours, err1 := strconv.Atoi(tz[1:3])
+	mins, err2 := strconv.Atoi(tz[3:5])
+	if err1 != nil || err2 != nil || (tz[0] != '+' && tz[0] != '-') {
+		return "", time.Time{}, fmt.Errorf("malformed time zone %q", tz)
+	}
+	offset := hours*3600 + mins*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return who, time.Unix(secs, 0).In(time.FixedZone("", offset)), nil
+}
+
+// Config returns the value of key in the repository's config file, where key
+// is of the form section.subsection.name, e.g. remote.origin.url.
+func (r *gitRepo) Config(key string) (string, error) {
+	f, err := os.Open(filepath.Join(r.commonDir, "config"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var section string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || l[0] == '#' || l[0] == ';' {
+			continue
+		}
+		if l[0] == '[' {
+			section = parseConfigSection(l)
+			continue
+		}
+		parts := strings.SplitN(l, "=", 2)
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if section+"."+name != key {
+			continue
+		}
+		if len(parts) == 1 {
+			// A bare name is a true boolean.
+			return "true", nil
+		}
+		return strings.Trim(strings.TrimSpace(parts[1]), `"`), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// parseConfigSection turns `[remote "origin"]` into "remote.origin".
+// Section names are case insensitive, subsection names are not.
+func parseConfigSection(l string) string {
+	l = strings.TrimSuffix(strings.TrimPrefix(l, "["), "]")
+	parts := strings.SplitN(l, " ", 2)
+	section := strings.ToLower(parts[0])
+	if len(parts) == 2 {
+		section += "." + strings.Trim(strings.TrimSpace(parts[1]), `"`)
+	}
+	return section
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"github.com/kisielk/gosrc"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitFixture creates a repository with the git command in a temporary
+// directory. The caller must remove the directory.
+func gitFixture(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "gosrc-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) {
+		runGit(t, dir, args...)
+	}
+	run("init", "-q")
+	run("config", "user.name", "Kamil Kisiel")
+	run("config", "user.email", "kamil@kamilkisiel.net")
+	run("remote", "add", "origin", "https://github.com/kisielk/gosrc.git")
+
+	// Enough similar content for repacking to produce deltas.
+	var content bytes.Buffer
+	for i := 0; i < 200; i++ {
+		content.WriteString(strings.Repeat("gosrc ", 10) + "\n")
+	}
+	for i := 0; i < 5; i++ {
+		content.WriteString("change\n")
+		writeFiles(t, dir, map[string]string{"sub/file.go": content.String()})
+		run("add", ".")
+		run("commit", "-q", "-m", "commit")
+	}
+	run("tag", "v0.1")
+	return dir
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_DATE=2014-05-26T15:30:45-0700",
+		"GIT_COMMITTER_DATE=2014-05-26T15:30:45-0700",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"HOME="+dir,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func checkGitFixture(t *testing.T, dir string) {
+	sub := filepath.Join(dir, "sub")
+
+	rev, err := Git.Revision(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date, _ := time.Parse(iso8601Date, "2014-05-26 15:30:45 -0700")
+	want := gosrc.Revision{
+		Id:     runGit(t, dir, "rev-parse", "--short=7", "HEAD"),
+		Author: "Kamil Kisiel <kamil@kamilkisiel.net>",
+		Date:   date,
+	}
+	if !revEqual(rev, want) {
+		t.Errorf("Revision: got %+v, want %+v", rev, want)
+	}
+	root, err := Git.Root(sub)
+	if want := runGit(t, sub, "rev-parse", "--show-toplevel"); root != want || err != nil {
+		t.Errorf("Root: got %q, %v, want %q", root, err, want)
+	}
+	url, err := Git.URL(sub)
+	if want := "https://github.com/kisielk/gosrc.git"; url != want || err != nil {
+		t.Errorf("URL: got %q, %v, want %q", url, err, want)
+	}
+	history, err := Git.History(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 5 || !revEqual(history[0], want) {
+		t.Errorf("History: got %+v, want 5 revisions starting with %+v", history, want)
+	}
+
+	// Every object must match what git itself reads.
+	r, err := openGitRepo(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := runGit(t, dir, "cat-file", "--batch-all-objects", "--batch-check=%(objectname)")
+	for _, hash := range strings.Fields(objects) {
+		_, data, err := r.Object(hash)
+		if err != nil {
+			t.Errorf("Object(%s): %s", hash, err)
+			continue
+		}
+		typ := runGit(t, dir, "cat-file", "-t", hash)
+		cmd := exec.Command("git", "cat-file", typ, hash)
+		cmd.Dir = dir
+		want, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want) {
+			t.Errorf("Object(%s): contents differ from git cat-file", hash)
+		}
+	}
+}
+
+func TestGitLoose(t *testing.T) {
+	dir := gitFixture(t)
+	defer os.RemoveAll(dir)
+	checkGitFixture(t, dir)
+}
+
+func TestGitPacked(t *testing.T) {
+	dir := gitFixture(t)
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "gc", "-q", "--aggressive")
+	if _, err := os.Stat(filepath.Join(dir, ".git", "packed-refs")); err != nil {
+		t.Fatal("expected packed refs:", err)
+	}
+	checkGitFixture(t, dir)
+}
+
+func TestGitTags(t *testing.T) {
+	dir := gitFixture(t)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{"sub/other.go": "package sub\n"})
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "-q", "-m", "after release")
+	runGit(t, dir, "tag", "-a", "-m", "release candidate", "v0.2-rc1")
+
+	branch, err := Git.Branch(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := runGit(t, dir, "symbolic-ref", "--short", "HEAD"); branch != want {
+		t.Errorf("Branch: got %q, want %q", branch, want)
+	}
+
+	tags, err := Git.Tags(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date, _ := time.Parse(iso8601Date, "2014-05-26 15:30:45 -0700")
+	want := []gosrc.Tag{
+		gosrc.NewTag("v0.1", runGit(t, dir, "rev-parse", "--short=7", "v0.1^{commit}"), date),
+		gosrc.NewTag("v0.2-rc1", runGit(t, dir, "rev-parse", "--short=7", "v0.2-rc1^{commit}"), date),
+	}
+	if len(tags) != len(want) {
+		t.Fatalf("Tags: got %+v, want %+v", tags, want)
+	}
+	for i := range tags {
+		if tags[i].Name != want[i].Name || tags[i].Revision != want[i].Revision ||
+			tags[i].Version != want[i].Version || !tags[i].Date.Equal(want[i].Date) {
+			t.Errorf("Tags[%d]: got %+v, want %+v", i, tags[i], want[i])
+		}
+	}
+
+	release, ok := gosrc.NewestRelease(tags)
+	if !ok || release.Name != "v0.1" {
+		t.Fatalf("NewestRelease: got %+v, %v, want v0.1", release, ok)
+	}
+	ahead, err := Git.Ahead(dir, release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ahead != 1 {
+		t.Errorf("Ahead: got %d, want 1", ahead)
+	}
+}
+
+func TestCheckout(t *testing.T) {
+	dir := gitFixture(t)
+	defer os.RemoveAll(dir)
+	first := runGit(t, dir, "rev-list", "--max-parents=0", "HEAD")
+	if err := checkout(filepath.Join(dir, "sub"), first); err != nil {
+		t.Fatal(err)
+	}
+	rev, err := Git.Revision(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Id != first[:7] {
+		t.Errorf("got revision %s after checkout, want %s", rev.Id, first[:7])
+	}
+	if err := checkout(dir, "no-such-revision"); err == nil {
+		t.Error("expected error checking out a missing revision")
+	}
+}
+
+func TestGitNotRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosrc-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := Git.Revision(dir); err != errNotRepository {
+		t.Fatalf("got %v, want %v", err, errNotRepository)
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello, world")
+	// Source size 12, target size 11, copy 5 bytes from offset 0, insert " gosrc".
+	delta := []byte{12, 11, 0x90, 5, 6, ' ', 'g', 'o', 's', 'r', 'c'}
+	got, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello gosrc" {
+		t.Fatalf("got %q, want %q", got, "hello gosrc")
+	}
+	if _, err := applyDelta(base, []byte{11, 11}); err != errBadDelta {
+		t.Fatalf("got %v for wrong source size, want %v", err, errBadDelta)
+	}
+}
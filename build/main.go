@@ -6,8 +6,10 @@ import (
 	"github.com/kisielk/gosrc"
 	"go/build"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -16,10 +18,31 @@ import (
 )
 
 var (
-	gopath      = flag.String("gopath", filepath.Join(os.TempDir(), "gosrc/gopath"), "GOPATH to use for builds")
-	numBuilders = flag.Int("builders", 8, "Number of concurrent builders")
-	mongo       = flag.String("mongo", "", "MongoDB host")
-	database    = flag.String("database", "test", "MongoDB database")
+	gopath             = flag.String("gopath", filepath.Join(os.TempDir(), "gosrc/gopath"), "GOPATH to use for builds")
+	numBuilders        = flag.Int("builders", 8, "Number of concurrent builders")
+	numDownloaders     = flag.Int("downloaders", 4, "Number of concurrent downloaders")
+	hostDownloads      = flag.Int("host-downloads", 2, "Maximum number of concurrent downloads from a single host")
+	hostDelay          = flag.Duration("host-delay", time.Second, "Minimum delay between starting downloads from the same host")
+	retries            = flag.Int("retries", 3, "Number of times to retry a download that failed with a transient error")
+	retryDelay         = flag.Duration("retry-delay", 5*time.Second, "Delay before the first download retry, doubled on each subsequent retry")
+	mongo              = flag.String("mongo", "", "MongoDB host")
+	database           = flag.String("database", "test", "MongoDB database")
+	force              = flag.Bool("force", false, "Rebuild packages even if their revision and toolchain are unchanged")
+	order              = flag.String("order", "depth", "Order in which to crawl discovered imports: depth, importers or fifo")
+	maxDepth           = flag.Int("max-depth", -1, "Maximum number of imports between a crawled package and the package list, or -1 for no limit")
+	expansion          = flag.String("expand", "all", "Imports to crawl: all, none (only record them), repo (those in the importer's repository) or host (those on the importer's host)")
+	statePath          = flag.String("state", "", "File in which to checkpoint crawl state")
+	checkpointInterval = flag.Duration("checkpoint", time.Minute, "Interval between crawl state checkpoints")
+	resume             = flag.Bool("resume", false, "Resume the crawl checkpointed in the -state file, whose results are in -mongo")
+	coordinatorAddr    = flag.String("coordinator", "", "HTTP address on which to hand out work to remote workers instead of building locally")
+	workerURL          = flag.String("worker", "", "URL of a coordinator to take work from, instead of crawling a package list. Workers only coordinate downloads within a process, so unless -gopath is given each uses a temporary GOPATH of its own")
+	leaseTimeout       = flag.Duration("lease", 30*time.Minute, "Time a remote worker has to finish a package before it is handed to another worker")
+	sandbox            = flag.String("sandbox", "none", "Isolation for commands run on downloaded code: none or bwrap")
+	isolate            = flag.Bool("isolate", false, "Build each package in its own workspace with a snapshot of its dependencies")
+	checkImports       = flag.Bool("check-imports", true, "Validate the go-import and go-source meta tags of vanity import paths")
+	filter             = gosrc.FilterFlags(flag.CommandLine)
+	depsDir            = flag.String("deps", "", "Crawl the dependencies of the Go project in this directory, instead of a package list, and print a summary of their health")
+	resolve            = flag.Bool("resolve", true, "Resolve each package's repository before downloading, so that no repository is downloaded twice at once")
 )
 
 var (
@@ -27,6 +50,9 @@ var (
 	gorootSrcPkg = filepath.Join(goroot, "src/pkg")
 )
 
+// goVersion is the output of `go version` for the toolchain used for builds.
+var goVersion string
+
 // isStd is a boolean map of packages in the Go standard library
 var isStd = func() map[string]bool {
 	pkgs := make(map[string]bool)
@@ -44,140 +70,132 @@ var isStd = func() map[string]bool {
 	return pkgs
 }()
 
-func getPackages(collection gosrc.Collection, gopath string, pkgs []string) {
-	downloadQueue := make(chan string)
-	buildQueue := make(chan string)
+// getPackages crawls the packages in state and the imports they lead to that
+// are within scope.
+func getPackages(collection gosrc.Collection, gopath string, state *crawlState, scope *gosrc.Filter) {
+	downloadQueue := make(chan crawlItem)
+	buildQueue := make(chan crawlItem)
+
+	var (
+		downloadResults chan downloadResult
+		buildResults    chan buildResult
+		finish          = func() {}
+	)
+	if *coordinatorAddr != "" {
+		// Remote workers download and build, and only report download failures.
+		downloadResults, buildResults, finish = startCoordinator(collection, *coordinatorAddr, state, downloadQueue)
+	} else {
+		downloadResults = startDownloader(gopath, state, downloadQueue)
+		buildResults = startBuilders(collection, gopath, *numBuilders, buildQueue)
+	}
 
-	downloadResults := startDownloader(gopath, pkgs, downloadQueue)
-	buildResults := startBuilders(gopath, *numBuilders, buildQueue)
+	rejected := make(map[string]bool)
+	var enqueue func(pkg string, depth int)
+	enqueue = func(pkg string, depth int) {
+		if reason := scope.Reject(pkg); reason != "" {
+			if !rejected[pkg] {
+				rejected[pkg] = true
+				log.Println(pkg, "out of scope:", reason)
+			}
+			return
+		}
+		item := crawlItem{path: pkg, depth: depth, options: state.Entry(pkg)}
+		if !state.Add(item) {
+			downloadQueue <- item
+			return
+		}
+		imports, err := lowerDepth(collection, pkg, depth)
+		if err != nil {
+			log.Println(pkg, "failed to lower depth:", err)
+		}
+		for _, imp := range imports {
+			enqueue(imp, depth+1)
+		}
+	}
+	checkpoint := time.NewTicker(*checkpointInterval)
+	defer checkpoint.Stop()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
 
-	downloading := len(pkgs)
-	for {
+	for len(state.Pending) > 0 {
 		select {
 		case r := <-downloadResults:
-			downloading--
 			if r.err != nil {
-				log.Println(r.pkg, "failed to download:", r.err)
+				log.Println(r.item.path, "failed to download:", r.err)
+				r.item.depth = state.Finish(r.item.path, r.item.depth)
+				if err := insertDownloadFailure(collection, r); err != nil {
+					log.Println(r.item.path, "failed to insert results:", err)
+				}
 			} else {
-				log.Println(r.pkg, "downloaded")
-				buildQueue <- r.pkg
+				log.Println(r.item.path, "downloaded")
+				for _, p := range followRepo(r.item.path, r.root, r.packages) {
+					enqueue(p, state.Depth(r.item.path, r.item.depth))
+				}
+				buildQueue <- r.item
 			}
 		case r := <-buildResults:
-			err := collection.Insert(r)
-			if err != nil {
-				log.Println(r, "failed to insert results:", err)
-				continue
-			}
-
-			for _, imp := range r.BuildInfo.Imports {
-				downloadQueue <- imp
+			r.depth = state.Finish(r.pkg.ImportPath, r.depth)
+			// Cached results are stored again if the package was reached
+			// by a different path this time.
+			if !r.cached || r.pkg.Depth != r.depth {
+				r.pkg.Depth = r.depth
+				err := collection.Insert(r.pkg)
+				if err != nil {
+					log.Println(r.pkg.ImportPath, "failed to insert results:", err)
+					continue
+				}
 			}
-		}
-	}
-}
-
-type downloadResult struct {
-	pkg string
-	err error
-}
-
-func startDownloader(gopath string, pkgs []string, downloadQueue chan string) chan downloadResult {
-	downloadRequests := make(chan string)
 
-	go func() {
-		queue := newOneTimeQueue()
-		for _, p := range pkgs {
-			queue.Push(p)
-		}
-
-		var next string
-		for {
-			if next == "" {
-				next = queue.Pop()
+			for _, p := range followRepo(r.pkg.ImportPath, r.root, r.packages) {
+				enqueue(p, r.depth)
 			}
-
-			if next != "" {
-				select {
-				case p := <-downloadQueue:
-					queue.Push(p)
-				case downloadRequests <- next:
-					next = ""
-				}
-			} else {
-				p := <-downloadQueue
-				queue.Push(p)
+			for _, imp := range follow(r.pkg, r.depth) {
+				enqueue(imp, r.depth+1)
 			}
+		case <-checkpoint.C:
+			saveCrawlState(state)
+		case sig := <-interrupt:
+			log.Println("stopping crawl:", sig)
+			saveCrawlState(state)
+			return
 		}
-	}()
-	return downloader(gopath, downloadRequests)
-}
-
-func downloader(gopath string, pkgs chan string) chan downloadResult {
-	results := make(chan downloadResult)
-	go func() {
-		for pkg := range pkgs {
-			log.Println(pkg, "downloading")
-			err := download(gopath, pkg)
-			results <- downloadResult{pkg, err}
-		}
-	}()
-	return results
-}
-
-type oneTimeQueue struct {
-	queue map[string]bool
-	seen  map[string]bool
-}
-
-func (q *oneTimeQueue) Push(s string) {
-	if !q.seen[s] {
-		q.queue[s] = true
-		q.seen[s] = true
 	}
+	saveCrawlState(state)
+	log.Println("crawl finished")
+	finish()
 }
 
-func (q *oneTimeQueue) Pop() string {
-	for next := range q.queue {
-		delete(q.queue, next)
-		return next
+// saveCrawlState checkpoints state to the -state file, if there is one.
+func saveCrawlState(state *crawlState) {
+	if *statePath == "" {
+		return
+	}
+	if err := state.Save(*statePath); err != nil {
+		log.Println("failed to save crawl state:", err)
 	}
-	return ""
 }
 
-func newOneTimeQueue() *oneTimeQueue {
-	return &oneTimeQueue{make(map[string]bool), make(map[string]bool)}
+type buildResult struct {
+	pkg    gosrc.Package
+	depth  int
+	cached bool // pkg is the existing record from the collection
+
+	// root and packages are the repository a remote worker fetched for
+	// pkg and the packages in it, as for downloadResult.
+	root     string
+	packages []string
 }
 
-func startBuilders(gopath string, builders int, buildQueue chan string) chan gosrc.Package {
-	buildRequests := make(chan string)
-	buildResults := make(chan gosrc.Package)
+func startBuilders(collection gosrc.Collection, gopath string, builders int, buildQueue chan crawlItem) chan buildResult {
+	buildRequests := make(chan crawlItem)
+	buildResults := make(chan buildResult)
 
 	for i := 0; i < builders; i++ {
-		go builder(gopath, buildRequests, buildResults)
+		go builder(collection, gopath, buildRequests, buildResults)
 	}
 
-	go func() {
-		queue := newOneTimeQueue()
-
-		var next string
-		for {
-			if next == "" {
-				next = queue.Pop()
-			}
-
-			if next != "" {
-				select {
-				case pkg := <-buildQueue:
-					queue.Push(pkg)
-				case buildRequests <- next:
-					next = ""
-				}
-			} else {
-				pkg := <-buildQueue
-				queue.Push(pkg)
-			}
-		}
-	}()
+	go runQueue(newCrawlQueue(orderings[*order]), buildQueue, buildRequests)
 	return buildResults
 }
 
@@ -191,19 +209,38 @@ func makeEnv(gopath string) []string {
 	return env
 }
 
+// getRepository detects the repository containing pkg, with its type,
+// revision, root and URL. Errors other than the package not being in a
+// repository of some kind are recorded on the result.
 func getRepository(gopath, pkg string) gosrc.Repository {
 	path := filepath.Join(gopath, "src", pkg)
 	var repo gosrc.Repository
+	var errs []string
 	for _, v := range AllVCS {
-		repo.Revision = v.Revision(path)
-		if repo.Revision.Id == "" {
+		rev, err := v.Revision(path)
+		if err == errNotRepository {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, v.Name()+": "+err.Error())
 			continue
 		}
 		repo.Type = v.Name()
-		repo.Root = v.Root(path)
-		repo.URL = v.URL(path)
+		repo.Revision = rev
+		errs = nil
+
+		if repo.Root, err = v.Root(path); err != nil {
+			errs = append(errs, v.Name()+" root: "+err.Error())
+		}
+		if repo.RawURL, err = v.URL(path); err != nil {
+			errs = append(errs, v.Name()+" url: "+err.Error())
+		}
+		repo.RawURL = gosrc.RedactURL(repo.RawURL)
+		repo.URL = gosrc.NormalizeURL(repo.RawURL)
 		break
 	}
+	repo.Error = strings.Join(errs, "; ")
+
 	path, err := filepath.Rel(filepath.Join(gopath, "src"), repo.Root)
 	if err != nil {
 		path = repo.Root
@@ -212,60 +249,115 @@ func getRepository(gopath, pkg string) gosrc.Repository {
 	return repo
 }
 
+// repositoryMetadata adds the branch, tags, newest release and commit
+// activity of the repository containing pkg to repo, which getRepository returned for it.
+// Reading them can take a while in large repositories, so unlike
+// getRepository it is only used for the package being built.
+func repositoryMetadata(gopath, pkg string, repo *gosrc.Repository) {
+	path := filepath.Join(gopath, "src", pkg)
+	var errs []string
+	if repo.Error != "" {
+		errs = append(errs, repo.Error)
+	}
+	for _, v := range AllVCS {
+		if v.Name() != repo.Type {
+			continue
+		}
+		var err error
+		if bt, ok := v.(branchTagger); ok {
+			if repo.Branch, err = bt.Branch(path); err != nil {
+				errs = append(errs, v.Name()+" branch: "+err.Error())
+			}
+			if repo.Tags, err = bt.Tags(path); err != nil {
+				errs = append(errs, v.Name()+" tags: "+err.Error())
+			}
+			if release, ok := gosrc.NewestRelease(repo.Tags); ok {
+				repo.Release = release
+				if repo.Ahead, err = bt.Ahead(path, release); err != nil {
+					errs = append(errs, v.Name()+" ahead: "+err.Error())
+				}
+			}
+		}
+		if h, ok := v.(historian); ok {
+			if history, err := h.History(path); err != nil {
+				errs = append(errs, v.Name()+" history: "+err.Error())
+			} else {
+				repo.Activity = gosrc.NewActivity(history, time.Now())
+			}
+		}
+	}
+	repo.Error = strings.Join(errs, "; ")
+}
+
 func goFmt(gopath, pkg string) (int, error) {
 	var out bytes.Buffer
-	cmd := exec.Command("gofmt", "-l", filepath.Join(gopath, "src", pkg))
-	cmd.Env = makeEnv(gopath)
+	cmd, done, err := stepCommand(gopath, pkg, false, "gofmt", "-l", filepath.Join(gopath, "src", pkg))
+	if err != nil {
+		return 0, err
+	}
+	defer done()
 	cmd.Stdout = &out
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	err = cmd.Run()
 	n := bytes.Count(out.Bytes(), []byte("\n"))
 	return n, err
 }
 
-func download(gopath, pkg string) error {
-	cmd := exec.Command("go", "get", "-d", "-u", pkg)
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// toolchainVersion returns the output of `go version`.
+func toolchainVersion() (string, error) {
+	out, err := exec.Command("go", "version").Output()
+	return strings.TrimSpace(string(out)), err
 }
 
 func buildPkg(gopath, pkg string) (string, error) {
 	var out bytes.Buffer
-	cmd := exec.Command("go", "get", pkg)
-	cmd.Env = makeEnv(gopath)
+	cmd, done, err := stepCommand(gopath, pkg, true, "go", "get", pkg)
+	if err != nil {
+		return "", err
+	}
+	defer done()
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = &out
-	err := cmd.Run()
+	err = cmd.Run()
 	return out.String(), err
 }
 
 func goTest(gopath, pkg string) (string, error) {
 	var out bytes.Buffer
-	cmd := exec.Command("go", "test", pkg)
-	cmd.Env = makeEnv(gopath)
+	cmd, done, err := stepCommand(gopath, pkg, false, "go", "test", pkg)
+	if err != nil {
+		return "", err
+	}
+	defer done()
 	cmd.Stdout = &out
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	err = cmd.Run()
 	return out.String(), err
 }
 
 func goVet(gopath, pkg string) (string, error) {
 	var out bytes.Buffer
-	cmd := exec.Command("go", "vet", pkg)
-	cmd.Env = makeEnv(gopath)
+	cmd, done, err := stepCommand(gopath, pkg, false, "go", "vet", pkg)
+	if err != nil {
+		return "", err
+	}
+	defer done()
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = &out
-	err := cmd.Run()
+	err = cmd.Run()
 	return out.String(), err
 }
 
 func errcheck(gopath, pkg string) (string, error) {
 	var out bytes.Buffer
-	cmd := exec.Command("errcheck", pkg)
-	cmd.Env = makeEnv(gopath)
+	cmd, done, err := stepCommand(gopath, pkg, false, "errcheck", pkg)
+	if err != nil {
+		return "", err
+	}
+	defer done()
 	cmd.Stdout = &out
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	err = cmd.Run()
 	if e1, ok := err.(*exec.ExitError); ok && exitStatus(e1) == 1 {
 		// errcheck returns 1 if there were errors found
 		err = nil
@@ -291,11 +383,31 @@ func importPkg(gopath, pkg string) *build.Package {
 	return buildPkg
 }
 
-func getPackage(gopath, pkg string) gosrc.Package {
+// newPackage returns the results for a downloaded package before it is
+// built.
+func newPackage(pkg string, options gosrc.ListEntry) gosrc.Package {
 	p := gosrc.Package{
+		Downloaded: true,
 		ImportPath: pkg,
 		Date:       time.Now(),
+		GoVersion:  goVersion,
+		Pinned:     options.Revision,
 	}
+	applyOptions(&p, options)
+	return p
+}
+
+// applyOptions sets the fields of p that describe its package list entry
+// but don't affect how it is built. It reports whether any of them changed.
+func applyOptions(p *gosrc.Package, options gosrc.ListEntry) bool {
+	listed := options.ImportPath != ""
+	changed := p.Listed != listed || p.Group != options.Group
+	p.Listed, p.Group = listed, options.Group
+	return changed
+}
+
+func getPackage(gopath, pkg string, options gosrc.ListEntry) gosrc.Package {
+	p := newPackage(pkg, options)
 
 	log.Println(pkg, "importing")
 	impPkg := importPkg(gopath, pkg)
@@ -303,6 +415,7 @@ func getPackage(gopath, pkg string) gosrc.Package {
 		return p
 	}
 	p.BuildInfo = gosrc.NewBuildInfo(impPkg)
+	p.Dependencies = dependencyRepositories(gopath, pkg)
 
 	log.Println(pkg, "building")
 	buildOut, err := buildPkg(gopath, pkg)
@@ -322,15 +435,20 @@ func getPackage(gopath, pkg string) gosrc.Package {
 			p.Gofmt.Differences = n
 		}
 
-		log.Println(pkg, "testing")
-		testOut, err := goTest(gopath, pkg)
-		if err != nil {
-			log.Println(pkg, "testing failed:", err)
+		if options.SkipTests {
+			log.Println(pkg, "skipping tests")
+			p.Test.Skipped = true
 		} else {
-			log.Println(pkg, "testing succeeded")
-			p.Test.Succeeded = true
+			log.Println(pkg, "testing")
+			testOut, err := goTest(gopath, pkg)
+			if err != nil {
+				log.Println(pkg, "testing failed:", err)
+			} else {
+				log.Println(pkg, "testing succeeded")
+				p.Test.Succeeded = true
+			}
+			p.Test.Log = testOut
 		}
-		p.Test.Log = testOut
 
 		log.Println(pkg, "vetting")
 		vetOut, err := goVet(gopath, pkg)
@@ -354,25 +472,118 @@ func getPackage(gopath, pkg string) gosrc.Package {
 
 	}
 	p.Repository = getRepository(gopath, pkg)
+	repositoryMetadata(gopath, pkg, &p.Repository)
+	if *checkImports {
+		log.Println(pkg, "checking import path")
+		p.ImportCheck = importChecker.CheckImport(pkg)
+		p.ImportCheck.CompareRepository(p.Repository)
+	}
 	return p
 }
 
-func builder(gopath string, pkgs chan string, results chan gosrc.Package) {
-	for pkg := range pkgs {
-		results <- getPackage(gopath, pkg)
+// importChecker validates vanity import paths.
+var importChecker = &gosrc.Resolver{Client: &http.Client{Timeout: 30 * time.Second}}
+
+// upToDate returns the stored record for pkg if it was built with options
+// from the revision currently in the GOPATH with the current toolchain.
+func upToDate(collection gosrc.Collection, gopath, pkg string, options gosrc.ListEntry) (gosrc.Package, bool) {
+	existing, err := collection.Get(pkg)
+	if err != nil {
+		if err != gosrc.ErrNotFound {
+			log.Println(pkg, "failed to look up existing results:", err)
+		}
+		return existing, false
+	}
+	if !reusable(existing, options) {
+		return existing, false
+	}
+	return existing, unchanged(gopath, pkg, options.Revision, existing.Repository.Revision.Id, existing.GoVersion)
+}
+
+// reusable reports whether the stored results in existing were built with
+// the options that affect the build, so that they can be reused if the
+// package hasn't changed.
+func reusable(existing gosrc.Package, options gosrc.ListEntry) bool {
+	if existing.Download.Error != "" || existing.Pinned != options.Revision {
+		return false
+	}
+	// Tests are only run, or skipped, once the package builds.
+	return !existing.Build.Succeeded || existing.Test.Skipped == options.SkipTests
+}
+
+// unchanged reports whether pkg, pinned to pin in the package list if that
+// is set, is at revision and version is the current toolchain. A pinned
+// package is only checked out at its pin in its own workspace, so results
+// built at the same pin are taken to be at revision; otherwise the GOPATH
+// must be at revision.
+func unchanged(gopath, pkg, pin, revision, version string) bool {
+	if revision == "" || version != goVersion {
+		return false
+	}
+	if pin != "" {
+		return true
+	}
+	return getRepository(gopath, pkg).Revision.Id == revision
+}
+
+func builder(collection gosrc.Collection, gopath string, items chan crawlItem, results chan buildResult) {
+	for item := range items {
+		pkg := item.path
+		if !*force {
+			if p, ok := upToDate(collection, gopath, pkg, item.options); ok {
+				log.Println(pkg, "unchanged, skipping build")
+				// Results whose list entry has changed are stored again.
+				results <- buildResult{pkg: p, depth: item.depth, cached: !applyOptions(&p, item.options)}
+				continue
+			}
+		}
+		results <- buildResult{pkg: buildPackage(gopath, pkg, item.options), depth: item.depth}
 	}
 }
 
 func main() {
 	flag.Parse()
-	packages := flag.Arg(0)
-	if packages == "" {
-		log.Fatalf("usage: %s [package list file]", os.Args[0])
+	if err := checkSandbox(); err != nil {
+		log.Fatalln("sandbox unavailable:", err)
+	}
+
+	if *workerURL != "" {
+		gopath, cleanup, err := workerGOPATH()
+		if err != nil {
+			log.Fatalln("failed to determine GOPATH:", err)
+		}
+		defer cleanup()
+		goVersion, err = toolchainVersion()
+		if err != nil {
+			log.Fatalln("failed to determine Go version:", err)
+		}
+		runWorkers(*workerURL, gopath, *numBuilders)
+		return
 	}
 
-	pkgList, err := gosrc.FilePackages(packages)
+	packages := flag.Arg(0)
+	if packages == "" && !*resume && *depsDir == "" {
+		log.Fatalf("usage: %s [package list file] or %s -deps DIR", os.Args[0], os.Args[0])
+	}
+	if packages != "" && *depsDir != "" {
+		log.Fatalln("-deps can't be used with a package list")
+	}
+	if *resume && *statePath == "" {
+		log.Fatalln("-resume requires -state")
+	}
+	if *resume && *mongo == "" {
+		// The results of finished packages are only kept in MongoDB.
+		log.Fatalln("-resume requires -mongo")
+	}
+	if _, ok := orderings[*order]; !ok {
+		log.Fatalln("unknown crawl order:", *order)
+	}
+	if _, ok := expansions[*expansion]; !ok {
+		log.Fatalln("unknown import expansion:", *expansion)
+	}
+	scope, err := filter()
 	if err != nil {
-		log.Fatalln("failed to read packages:", err)
+		log.Fatalln("bad filter:", err)
 	}
 
 	gopath, err := filepath.Abs(*gopath)
@@ -380,6 +591,59 @@ func main() {
 		log.Fatalln("failed to determine GOPATH:", err)
 	}
 
+	var proj *project
+	if *depsDir != "" {
+		proj, err = loadProject(gopath, *depsDir)
+		if err != nil {
+			log.Fatalln("failed to load project:", err)
+		}
+		log.Println(proj.ImportPath, "has", len(proj.Deps), "known dependencies")
+		// The project's own packages are never crawled, even if a
+		// dependency imports them.
+		scope.Exclude = append(scope.Exclude, proj.ImportPath+"/...")
+		if err := scope.Compile(); err != nil {
+			log.Fatalln("bad filter:", err)
+		}
+	}
+
+	var state *crawlState
+	if *resume {
+		var err error
+		state, err = loadCrawlState(*statePath)
+		if err != nil {
+			log.Fatalln("failed to load crawl state:", err)
+		}
+		log.Println("resuming crawl with", len(state.Pending), "pending and", len(state.Done), "finished packages")
+	} else {
+		var entries []gosrc.ListEntry
+		if proj != nil {
+			entries = proj.Entries(*maxDepth)
+		} else if entries, err = gosrc.ReadListFile(packages); err != nil {
+			log.Fatalln("failed to read packages:", err)
+		}
+		var inScope []gosrc.ListEntry
+		for _, e := range entries {
+			if reason := scope.Reject(e.ImportPath); reason != "" {
+				log.Println(e.ImportPath, "out of scope:", reason)
+				continue
+			}
+			inScope = append(inScope, e)
+		}
+		state = newCrawlState(inScope)
+		if proj != nil {
+			state.Modules = proj.Modules
+			// Dependencies found through others start at their depth.
+			for pkg := range state.Pending {
+				state.Pending[pkg] = proj.Deps[pkg]
+			}
+		}
+	}
+
+	goVersion, err = toolchainVersion()
+	if err != nil {
+		log.Fatalln("failed to determine Go version:", err)
+	}
+
 	var collection gosrc.Collection
 	if *mongo != "" {
 		var err error
@@ -391,11 +655,22 @@ func main() {
 		collection = gosrc.NewMemoryCollection()
 	}
 
-	getPackages(collection, gopath, pkgList)
+	getPackages(collection, gopath, state, scope)
 
 	if *mongo == "" {
 		c := collection.(*gosrc.MemoryCollection)
 		out, _ := c.Dump()
-		log.Println("result:", out)
+		log.Printf("result: %s", out)
+	}
+
+	if proj != nil {
+		// Only the project's dependencies and their imports are crawled.
+		var deps []string
+		for pkg := range state.Done {
+			deps = append(deps, pkg)
+		}
+		if err := writeHealth(os.Stdout, collection, deps); err != nil {
+			log.Fatalln("failed to write summary:", err)
+		}
 	}
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/kisielk/gosrc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpToDate(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "gosrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	dir := gitFixture(t)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(gopath, "src", "example.com", "repo")
+	if err := os.MkdirAll(filepath.Dir(root), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(dir, root); err != nil {
+		t.Fatal(err)
+	}
+	const pkg = "example.com/repo/sub"
+	rev := runGit(t, root, "rev-parse", "--short=7", "HEAD")
+
+	defer func(v string) { goVersion = v }(goVersion)
+	goVersion = "go version go1.2 linux/amd64"
+
+	stored := func(revision, version, downloadErr string) gosrc.Package {
+		p := gosrc.Package{ImportPath: pkg, GoVersion: version}
+		p.Repository.Revision.Id = revision
+		p.Download.Error = downloadErr
+		return p
+	}
+	pinned := stored("0000000", goVersion, "")
+	pinned.Pinned = "v1.0.0"
+	tested := stored(rev, goVersion, "")
+	tested.Build.Succeeded = true
+	var tests = []struct {
+		name    string
+		stored  []gosrc.Package
+		options gosrc.ListEntry
+		want    bool
+	}{
+		{"unchanged", []gosrc.Package{stored(rev, goVersion, "")}, gosrc.ListEntry{}, true},
+		{"not stored", nil, gosrc.ListEntry{}, false},
+		{"new revision", []gosrc.Package{stored("0000000", goVersion, "")}, gosrc.ListEntry{}, false},
+		{"new toolchain", []gosrc.Package{stored(rev, "go version go1.1 linux/amd64", "")}, gosrc.ListEntry{}, false},
+		{"download failed", []gosrc.Package{stored(rev, goVersion, "exit status 1")}, gosrc.ListEntry{}, false},
+		{"newly pinned", []gosrc.Package{stored(rev, goVersion, "")}, gosrc.ListEntry{Revision: "v1.0.0"}, false},
+		{"same pin", []gosrc.Package{pinned}, gosrc.ListEntry{Revision: "v1.0.0"}, true},
+		{"new pin", []gosrc.Package{pinned}, gosrc.ListEntry{Revision: "v1.1.0"}, false},
+		{"unpinned", []gosrc.Package{pinned}, gosrc.ListEntry{}, false},
+		{"tests run", []gosrc.Package{tested}, gosrc.ListEntry{}, true},
+		{"tests now skipped", []gosrc.Package{tested}, gosrc.ListEntry{SkipTests: true}, false},
+	}
+	for _, test := range tests {
+		collection := gosrc.NewMemoryCollection()
+		for _, p := range test.stored {
+			if err := collection.Insert(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, got := upToDate(collection, gopath, pkg, test.options); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// The builder passes on unchanged results without building, unless
+	// -force is set. Results whose group changed aren't the stored ones.
+	collection := gosrc.NewMemoryCollection()
+	if err := collection.Insert(stored(rev, goVersion, "")); err != nil {
+		t.Fatal(err)
+	}
+	defer func(f bool) { *force = f }(*force)
+	var builds = []struct {
+		force   bool
+		options gosrc.ListEntry
+		cached  bool
+	}{
+		{false, gosrc.ListEntry{}, true},
+		{false, gosrc.ListEntry{ImportPath: pkg, Group: "team"}, false},
+		{true, gosrc.ListEntry{}, false},
+	}
+	for _, test := range builds {
+		*force = test.force
+		items := make(chan crawlItem, 1)
+		results := make(chan buildResult, 1)
+		items <- crawlItem{path: pkg, depth: 1, options: test.options}
+		close(items)
+		builder(collection, gopath, items, results)
+		r := <-results
+		if r.cached != test.cached || r.pkg.ImportPath != pkg || r.depth != 1 || r.pkg.Group != test.options.Group {
+			t.Errorf("force %v, options %+v: got result %+v", test.force, test.options, r)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"container/heap"
+	"github.com/kisielk/gosrc"
+)
+
+// crawlItem is a package waiting to be downloaded or built.
+type crawlItem struct {
+	path    string
+	depth   int             // distance from the package list, whose packages are at depth 0
+	options gosrc.ListEntry // set for packages from the package list
+}
+
+// listed reports whether the item is from the package list. Other packages
+// in the repository of a listed package are at depth 0 too, but aren't.
+func (item crawlItem) listed() bool {
+	return item.options.ImportPath != ""
+}
+
+type queueEntry struct {
+	crawlItem
+	importers int // number of times the path was pushed as an import
+	seq       int // order in which the path was first pushed
+	index     int // position in the heap
+}
+
+// An ordering reports whether a should be crawled before b.
+// Packages from the package list always come first, and the crawl queue
+// puts packages given a higher priority in the list before any ordering.
+type ordering func(a, b *queueEntry) bool
+
+var orderings = map[string]ordering{
+	// depth crawls shallower imports first.
+	"depth": func(a, b *queueEntry) bool {
+		if a.listed() != b.listed() {
+			return a.listed()
+		}
+		if a.depth != b.depth {
+			return a.depth < b.depth
+		}
+		return a.seq < b.seq
+	},
+	// importers crawls the most imported paths first.
+	"importers": func(a, b *queueEntry) bool {
+		if a.listed() != b.listed() {
+			return a.listed()
+		}
+		if a.importers != b.importers {
+			return a.importers > b.importers
+		}
+		return a.seq < b.seq
+	},
+	// fifo crawls paths in the order they were discovered.
+	"fifo": func(a, b *queueEntry) bool {
+		if a.listed() != b.listed() {
+			return a.listed()
+		}
+		return a.seq < b.seq
+	},
+}
+
+// crawlQueue is a priority queue that yields each path at most once.
+type crawlQueue struct {
+	entries []*queueEntry
+	queued  map[string]*queueEntry
+	seen    map[string]bool
+	less    ordering
+	seq     int
+}
+
+func newCrawlQueue(less ordering) *crawlQueue {
+	return &crawlQueue{
+		queued: make(map[string]*queueEntry),
+		seen:   make(map[string]bool),
+		less: func(a, b *queueEntry) bool {
+			if a.options.Priority != b.options.Priority {
+				return a.options.Priority > b.options.Priority
+			}
+			return less(a, b)
+		},
+	}
+}
+
+// Push adds item to the queue unless its path has been pushed before. Pushing
+// a path that is still queued updates its priority instead.
+func (q *crawlQueue) Push(item crawlItem) {
+	if e, ok := q.queued[item.path]; ok {
+		e.importers++
+		if item.depth < e.depth {
+			e.depth = item.depth
+		}
+		if item.options.ImportPath != "" {
+			e.options = item.options
+		}
+		heap.Fix((*queueHeap)(q), e.index)
+		return
+	}
+	if q.seen[item.path] {
+		return
+	}
+	q.seen[item.path] = true
+	e := &queueEntry{crawlItem: item, seq: q.seq}
+	if item.depth > 0 {
+		e.importers = 1
+	}
+	q.seq++
+	q.queued[item.path] = e
+	heap.Push((*queueHeap)(q), e)
+}
+
+// MarkSeen prevents path from being queued by later pushes.
+func (q *crawlQueue) MarkSeen(path string) {
+	q.seen[path] = true
+}
+
+// Peek returns the item with the highest priority without removing it.
+// It returns false if the queue is empty.
+func (q *crawlQueue) Peek() (crawlItem, bool) {
+	if len(q.entries) == 0 {
+		return crawlItem{}, false
+	}
+	return q.entries[0].crawlItem, true
+}
+
+// Pop removes and returns the item with the highest priority. It returns
+// false if the queue is empty.
+func (q *crawlQueue) Pop() (crawlItem, bool) {
+	if len(q.entries) == 0 {
+		return crawlItem{}, false
+	}
+	e := heap.Pop((*queueHeap)(q)).(*queueEntry)
+	delete(q.queued, e.path)
+	return e.crawlItem, true
+}
+
+// queueHeap implements heap.Interface for a crawlQueue.
+type queueHeap crawlQueue
+
+func (h *queueHeap) Len() int { return len(h.entries) }
+
+func (h *queueHeap) Less(i, j int) bool { return h.less(h.entries[i], h.entries[j]) }
+
+func (h *queueHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *queueHeap) Push(x interface{}) {
+	e := x.(*queueEntry)
+	e.index = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *queueHeap) Pop() interface{} {
+	n := len(h.entries)
+	e := h.entries[n-1]
+	h.entries[n-1] = nil
+	h.entries = h.entries[:n-1]
+	return e
+}
+
+// runQueue feeds items received on in through queue and sends them on out
+// in priority order.
+func runQueue(queue *crawlQueue, in <-chan crawlItem, out chan<- crawlItem) {
+	for {
+		next, ok := queue.Peek()
+		if !ok {
+			queue.Push(<-in)
+			continue
+		}
+		select {
+		case item := <-in:
+			queue.Push(item)
+		case out <- next:
+			queue.Pop()
+		}
+	}
+}
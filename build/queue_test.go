@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/kisielk/gosrc"
+	"reflect"
+	"testing"
+)
+
+func popAll(q *crawlQueue) []string {
+	var paths []string
+	for {
+		item, ok := q.Pop()
+		if !ok {
+			return paths
+		}
+		paths = append(paths, item.path)
+	}
+}
+
+func TestCrawlQueueOrder(t *testing.T) {
+	var tests = []struct {
+		order string
+		want  []string
+	}{
+		{"depth", []string{"seed", "sibling", "a", "c", "b"}},
+		{"importers", []string{"seed", "c", "a", "b", "sibling"}},
+		{"fifo", []string{"seed", "a", "b", "c", "sibling"}},
+	}
+	for _, test := range tests {
+		q := newCrawlQueue(orderings[test.order])
+		q.Push(crawlItem{path: "a", depth: 1})
+		q.Push(crawlItem{path: "b", depth: 2})
+		q.Push(crawlItem{path: "c", depth: 2})
+		q.Push(crawlItem{path: "seed", options: gosrc.ListEntry{ImportPath: "seed"}})
+		// In the same repository as seed, but not in the package list.
+		q.Push(crawlItem{path: "sibling"})
+		q.Push(crawlItem{path: "c", depth: 1})
+		q.Push(crawlItem{path: "c", depth: 3})
+		if got := popAll(q); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.order, got, test.want)
+		}
+	}
+}
+
+func TestCrawlQueueOnce(t *testing.T) {
+	q := newCrawlQueue(orderings["depth"])
+	q.Push(crawlItem{path: "a"})
+	popAll(q)
+	q.Push(crawlItem{path: "a", depth: 1})
+	if got := popAll(q); len(got) != 0 {
+		t.Fatalf("got %v after pushing a popped path again, want nothing", got)
+	}
+}
+
+func TestCrawlQueuePriority(t *testing.T) {
+	for order := range orderings {
+		q := newCrawlQueue(orderings[order])
+		q.Push(crawlItem{path: "seed", options: gosrc.ListEntry{ImportPath: "seed"}})
+		q.Push(crawlItem{path: "low", options: gosrc.ListEntry{ImportPath: "low", Priority: -1}})
+		q.Push(crawlItem{path: "high", options: gosrc.ListEntry{ImportPath: "high", Priority: 5}})
+		q.Push(crawlItem{path: "dep", depth: 1})
+		if got, want := popAll(q), []string{"high", "seed", "dep", "low"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", order, got, want)
+		}
+	}
+}
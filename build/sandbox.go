@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// checkSandbox verifies that the isolation selected with -sandbox can be used.
+func checkSandbox() error {
+	switch *sandbox {
+	case "none":
+		return nil
+	case "bwrap":
+		_, err := exec.LookPath("bwrap")
+		return err
+	default:
+		return fmt.Errorf("unknown sandbox %q", *sandbox)
+	}
+}
+
+// sandboxCommand returns a command that runs name with args, isolated
+// according to -sandbox.
+//
+// With bwrap the command gets its own user, PID, IPC and network namespaces
+// and sees the whole filesystem read-only, except for the writable
+// directories and the private ones, which are mounted writable over the
+// shared directories they are keyed by. It only has network access if
+// network is set.
+func sandboxCommand(writable []string, private map[string]string, network bool, name string, args ...string) *exec.Cmd {
+	if *sandbox != "bwrap" {
+		return exec.Command(name, args...)
+	}
+
+	bwrapArgs := []string{
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--unshare-all",
+		"--die-with-parent",
+		"--new-session",
+	}
+	if network {
+		bwrapArgs = append(bwrapArgs, "--share-net")
+	}
+	for _, dir := range writable {
+		bwrapArgs = append(bwrapArgs, "--bind", dir, dir)
+	}
+	for shared, dir := range private {
+		bwrapArgs = append(bwrapArgs, "--bind", dir, shared)
+	}
+	bwrapArgs = append(bwrapArgs, "--", name)
+	return exec.Command("bwrap", append(bwrapArgs, args...)...)
+}
+
+// stepCommand returns a command for a step that builds or checks pkg, and a
+// function to call once it has run to remove its private directories.
+//
+// When sandboxed, the package's own directory is the only writable part of
+// the GOPATH. The step gets empty pkg and bin directories of its own in
+// place of the GOPATH's, and its own scratch and build cache directories,
+// so that it can't tamper with the build output of other packages.
+func stepCommand(gopath, pkg string, network bool, name string, args ...string) (*exec.Cmd, func(), error) {
+	env := makeEnv(gopath)
+	var (
+		writable []string
+		private  map[string]string
+		cleanup  = func() {}
+	)
+	if *sandbox == "bwrap" {
+		scratch, err := ioutil.TempDir("", "gosrc-step")
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup = func() { os.RemoveAll(scratch) }
+		tmp := filepath.Join(scratch, "tmp")
+		cache := filepath.Join(scratch, "cache")
+		writable = []string{filepath.Join(gopath, "src", pkg), tmp, cache}
+		private = map[string]string{
+			filepath.Join(gopath, "pkg"): filepath.Join(scratch, "pkg"),
+			filepath.Join(gopath, "bin"): filepath.Join(scratch, "bin"),
+		}
+		dirs := append([]string{}, writable...)
+		for shared, dir := range private {
+			// The shared directories must exist to be mounted over.
+			dirs = append(dirs, shared, dir)
+		}
+		for _, dir := range dirs {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				log.Println(pkg, "failed to create sandbox directory:", err)
+			}
+		}
+		env = append(env, "TMPDIR="+tmp, "GOCACHE="+cache)
+	}
+
+	cmd := sandboxCommand(writable, private, network, name, args...)
+	cmd.Env = env
+	return cmd, cleanup, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/kisielk/gosrc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// crawlState is the set of packages a crawl has discovered. It is
+// checkpointed to disk so that an interrupted crawl can be resumed.
+type crawlState struct {
+	Pending map[string]int             // import path to depth, for packages not yet finished
+	Done    map[string]bool            // packages that have been built or failed to download
+	Depths  map[string]int             // shortest depth at which each finished package was reached
+	Options map[string]gosrc.ListEntry // options given in the package list
+
+	// Modules maps modules to the revisions to crawl their packages at,
+	// for packages not in the package list. See project.Modules.
+	Modules map[string]string `json:",omitempty"`
+}
+
+func newCrawlState(entries []gosrc.ListEntry) *crawlState {
+	s := &crawlState{
+		Pending: make(map[string]int),
+		Done:    make(map[string]bool),
+		Depths:  make(map[string]int),
+		Options: make(map[string]gosrc.ListEntry),
+	}
+	for _, e := range entries {
+		s.Options[e.ImportPath] = e
+		s.Add(crawlItem{path: e.ImportPath, options: e})
+	}
+	return s
+}
+
+// loadCrawlState reads a state previously written by Save.
+func loadCrawlState(path string) (*crawlState, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := newCrawlState(nil)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add records item as pending unless it has already been seen, lowering
+// the depth of a pending package if item reaches it by a shorter path. It
+// reports whether item reaches a finished package by a shorter path, in
+// which case the package's depth is lowered, but it isn't crawled again.
+func (s *crawlState) Add(item crawlItem) bool {
+	if s.Done[item.path] {
+		if depth, ok := s.Depths[item.path]; ok && item.depth < depth {
+			s.Depths[item.path] = item.depth
+			return true
+		}
+		return false
+	}
+	if depth, ok := s.Pending[item.path]; ok && depth <= item.depth {
+		return false
+	}
+	s.Pending[item.path] = item.depth
+	return false
+}
+
+// Depth returns the shortest depth at which pkg has been reached, given
+// that it was crawled at depth.
+func (s *crawlState) Depth(pkg string, depth int) int {
+	if d, ok := s.Pending[pkg]; ok && d < depth {
+		return d
+	}
+	return depth
+}
+
+// Finish records that the crawl is done with pkg, which was crawled at
+// depth, and returns the shortest depth at which it was reached.
+func (s *crawlState) Finish(pkg string, depth int) int {
+	depth = s.Depth(pkg, depth)
+	delete(s.Pending, pkg)
+	s.Done[pkg] = true
+	s.Depths[pkg] = depth
+	return depth
+}
+
+// Entry returns the options for pkg given in the package list, or else those
+// pinning it to the revision of its module in Modules. Only entries from the
+// package list have an ImportPath.
+func (s *crawlState) Entry(pkg string) gosrc.ListEntry {
+	if e, ok := s.Options[pkg]; ok {
+		return e
+	}
+	if rev := moduleRevision(s.Modules, pkg); rev != "" {
+		return gosrc.ListEntry{Revision: rev}
+	}
+	return gosrc.ListEntry{}
+}
+
+// Items returns the pending packages.
+func (s *crawlState) Items() []crawlItem {
+	items := make([]crawlItem, 0, len(s.Pending))
+	for p, depth := range s.Pending {
+		items = append(items, crawlItem{path: p, depth: depth, options: s.Entry(p)})
+	}
+	return items
+}
+
+// Save atomically writes the state to path.
+func (s *crawlState) Save(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
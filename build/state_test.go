@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/kisielk/gosrc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCrawlStateSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newCrawlState([]gosrc.ListEntry{{ImportPath: "a"}, {ImportPath: "b", Priority: 2, SkipTests: true}})
+	s.Add(crawlItem{path: "c", depth: 2})
+	s.Add(crawlItem{path: "c", depth: 1})
+	if depth := s.Finish("a", 0); depth != 0 {
+		t.Errorf("Finish: got depth %d, want 0", depth)
+	}
+	if s.Add(crawlItem{path: "a", depth: 1}) {
+		t.Error("Add reported a longer path to a finished package as shorter")
+	}
+
+	path := filepath.Join(dir, "state.json")
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadCrawlState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantPending := map[string]int{"b": 0, "c": 1}
+	if !reflect.DeepEqual(loaded.Pending, wantPending) {
+		t.Errorf("got pending %v, want %v", loaded.Pending, wantPending)
+	}
+	wantDone := map[string]bool{"a": true}
+	if !reflect.DeepEqual(loaded.Done, wantDone) {
+		t.Errorf("got done %v, want %v", loaded.Done, wantDone)
+	}
+	wantItem := crawlItem{path: "b", options: gosrc.ListEntry{ImportPath: "b", Priority: 2, SkipTests: true}}
+	for _, item := range loaded.Items() {
+		if item.path == "b" && item != wantItem {
+			t.Errorf("got item %+v, want %+v", item, wantItem)
+		}
+	}
+}
+
+func TestCrawlStateDepth(t *testing.T) {
+	s := newCrawlState(nil)
+	s.Add(crawlItem{path: "a", depth: 3})
+	// A shorter path found while a is being crawled is kept.
+	s.Add(crawlItem{path: "a", depth: 1})
+	if depth := s.Finish("a", 3); depth != 1 {
+		t.Errorf("Finish: got depth %d, want 1", depth)
+	}
+	if s.Add(crawlItem{path: "a", depth: 1}) {
+		t.Error("Add reported an equal path to a finished package as shorter")
+	}
+	// A shorter path found after a is finished lowers its depth.
+	if !s.Add(crawlItem{path: "a", depth: 0}) {
+		t.Error("Add didn't report a shorter path to a finished package")
+	}
+	if s.Depths["a"] != 0 || len(s.Pending) != 0 {
+		t.Errorf("got depths %v and pending %v after a shorter path", s.Depths, s.Pending)
+	}
+}
+
+func TestCrawlStateEntry(t *testing.T) {
+	s := newCrawlState([]gosrc.ListEntry{{ImportPath: "example.org/m/listed", Revision: "v0.9.0"}})
+	s.Modules = map[string]string{"example.org/m": "v1.0.0"}
+	var tests = []struct {
+		pkg  string
+		want gosrc.ListEntry
+	}{
+		{"example.org/m/listed", gosrc.ListEntry{ImportPath: "example.org/m/listed", Revision: "v0.9.0"}},
+		{"example.org/m/sub", gosrc.ListEntry{Revision: "v1.0.0"}},
+		{"example.org/mod", gosrc.ListEntry{}},
+	}
+	for _, test := range tests {
+		if got := s.Entry(test.pkg); got != test.want {
+			t.Errorf("Entry(%q) = %+v, want %+v", test.pkg, got, test.want)
+		}
+	}
+}
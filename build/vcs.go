@@ -2,42 +2,74 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"github.com/kisielk/gosrc"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-func vcsCmd(dir, cmd string, args ...string) string {
-	var buf bytes.Buffer
-	c := exec.Command(cmd, args...)
+// errNotRepository is returned by VCS methods for a directory that isn't
+// under that kind of version control.
+var errNotRepository = errors.New("not a repository")
+
+// vcsCmd runs a VCS command in dir and returns its output. If the command
+// fails with notRepo in its error output, it returns errNotRepository.
+func vcsCmd(dir, notRepo, cmd string, args ...string) (string, error) {
+	return runVCS(nil, dir, notRepo, cmd, args...)
+}
+
+// runVCS is like vcsCmd, but lets the command write to the writable
+// directories.
+func runVCS(writable []string, dir, notRepo, cmd string, args ...string) (string, error) {
+	if _, err := exec.LookPath(cmd); err != nil {
+		return "", fmt.Errorf("%s is not installed", cmd)
+	}
+	var stdout, stderr bytes.Buffer
+	c := sandboxCommand(writable, nil, false, cmd, args...)
 	c.Dir = dir
-	c.Stdout = &buf
+	c.Stdout = &stdout
+	c.Stderr = &stderr
 	err := c.Run()
 	if err != nil {
-		return ""
+		msg := strings.TrimSpace(stderr.String())
+		if notRepo != "" && strings.Contains(msg, notRepo) {
+			return "", errNotRepository
+		}
+		if msg == "" {
+			return "", fmt.Errorf("%s %s: %s", cmd, args[0], err)
+		}
+		return "", fmt.Errorf("%s %s: %s: %s", cmd, args[0], err, msg)
 	}
-	return strings.TrimSpace(buf.String())
+	return strings.TrimSpace(stdout.String()), nil
 }
 
 const (
 	iso8601Date = "2006-01-02 15:04:05 -0700"
 	bzrDate     = "Mon 2006-01-02 15:04:05 -0700"
+	fossilDate  = "2006-01-02 15:04:05 MST"
 )
 
-func parseRevision(s string) gosrc.Revision {
+func parseRevision(s string) (gosrc.Revision, error) {
 	var rev gosrc.Revision
 	parts := strings.Split(s, "\n")
-	if len(parts) == 3 {
-		rev.Id = parts[0]
-		d, _ := time.Parse(iso8601Date, parts[1])
-		rev.Date = d
-		rev.Author = parts[2]
+	if len(parts) != 3 {
+		return rev, fmt.Errorf("malformed revision %q", s)
 	}
-	return rev
+	d, err := time.Parse(iso8601Date, parts[1])
+	if err != nil {
+		return rev, err
+	}
+	rev.Id = parts[0]
+	rev.Date = d
+	rev.Author = parts[2]
+	return rev, nil
 }
 
-func parseBzrRevision(s string) gosrc.Revision {
+func parseBzrRevision(s string) (gosrc.Revision, error) {
 	var rev gosrc.Revision
 	for _, l := range strings.Split(s, "\n") {
 		parts := strings.SplitN(l, " ", 2)
@@ -48,21 +80,135 @@ func parseBzrRevision(s string) gosrc.Revision {
 			case "committer:":
 				rev.Author = parts[1]
 			case "timestamp:":
-				d, _ := time.Parse(bzrDate, parts[1])
+				d, err := time.Parse(bzrDate, parts[1])
+				if err != nil {
+					return rev, err
+				}
 				rev.Date = d
 			}
 		}
 	}
-	return rev
+	if rev.Id == "" {
+		return rev, fmt.Errorf("no revno in bzr log output %q", s)
+	}
+	return rev, nil
+}
+
+// parseInfo parses the "key: value" lines printed by `svn info` and
+// `fossil info`.
+func parseInfo(s string) map[string]string {
+	info := make(map[string]string)
+	for _, l := range strings.Split(s, "\n") {
+		parts := strings.SplitN(l, ":", 2)
+		if len(parts) == 2 {
+			info[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return info
+}
+
+func parseSvnRevision(s string) (gosrc.Revision, error) {
+	var rev gosrc.Revision
+	info := parseInfo(s)
+	rev.Id = info["Last Changed Rev"]
+	if rev.Id == "" {
+		return rev, fmt.Errorf("no revision in svn info output %q", s)
+	}
+	rev.Author = info["Last Changed Author"]
+	// Last Changed Date: 2014-05-26 15:30:45 -0700 (Mon, 26 May 2014)
+	date := info["Last Changed Date"]
+	if i := strings.Index(date, " ("); i >= 0 {
+		date = date[:i]
+	}
+	d, err := time.Parse(iso8601Date, date)
+	if err != nil {
+		return rev, err
+	}
+	rev.Date = d
+	return rev, nil
+}
+
+func parseFossilRevision(s string) (gosrc.Revision, error) {
+	var rev gosrc.Revision
+	info := parseInfo(s)
+	// checkout: 3e5975aa3bb1df18e14845a1e1c5a1cbac3a3d7c 2014-05-26 15:30:45 UTC
+	parts := strings.SplitN(info["checkout"], " ", 2)
+	if len(parts) != 2 {
+		return rev, fmt.Errorf("no checkout in fossil info output %q", s)
+	}
+	rev.Id = parts[0]
+	if len(rev.Id) > 10 {
+		rev.Id = rev.Id[:10]
+	}
+	d, err := time.Parse(fossilDate, parts[1])
+	if err != nil {
+		return rev, err
+	}
+	rev.Date = d
+	// comment: Fix a problem. (user: drh)
+	comment := info["comment"]
+	if i := strings.LastIndex(comment, "(user: "); i >= 0 && strings.HasSuffix(comment, ")") {
+		rev.Author = comment[i+len("(user: ") : len(comment)-1]
+	}
+	return rev, nil
 }
 
+// VCS reads information about the repository containing a directory.
+// Methods return errNotRepository if the directory isn't in a repository
+// of that kind.
 type VCS interface {
 	Name() string
-	Revision(dir string) gosrc.Revision
-	Root(dir string) string
-	URL(dir string) string
+	Revision(dir string) (gosrc.Revision, error)
+	Root(dir string) (string, error)
+	URL(dir string) (string, error)
+}
+
+// branchTagger is implemented by VCS types that can report the current branch
+// and the tags of a repository, and how many commits have been made since a tag.
+type branchTagger interface {
+	Branch(dir string) (string, error)
+	Tags(dir string) ([]gosrc.Tag, error)
+	Ahead(dir string, tag gosrc.Tag) (int, error)
+}
+
+// checkouter is implemented by VCS types that can update the working tree of
+// a repository, given by its root directory, to a revision.
+type checkouter interface {
+	Checkout(root, rev string) error
+}
+
+// checkout updates the repository containing dir to rev.
+func checkout(dir, rev string) error {
+	var errs []string
+	for _, v := range AllVCS {
+		root, err := v.Root(dir)
+		if err == errNotRepository {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, v.Name()+": "+err.Error())
+			continue
+		}
+		c, ok := v.(checkouter)
+		if !ok {
+			return fmt.Errorf("can't check out %s revisions", v.Name())
+		}
+		return c.Checkout(root, rev)
+	}
+	if errs != nil {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return errNotRepository
+}
+
+// historian is implemented by VCS types that can list the commits leading
+// to the current revision.
+type historian interface {
+	History(dir string) ([]gosrc.Revision, error)
 }
 
+// git reads repositories directly rather than running the git command. Only
+// Checkout, which changes the working tree, runs it.
 type git struct {
 }
 
@@ -70,63 +216,457 @@ func (g git) Name() string {
 	return "git"
 }
 
-func (g git) Revision(dir string) gosrc.Revision {
-	s := vcsCmd(dir, "git", "log", "--pretty=format:%h%n%ai%n%an <%ae>", "-1")
-	return parseRevision(s)
+func (g git) Revision(dir string) (gosrc.Revision, error) {
+	var rev gosrc.Revision
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return rev, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return rev, fmt.Errorf("reading HEAD: %s", err)
+	}
+	c, err := r.Commit(head)
+	if err != nil {
+		return rev, err
+	}
+	rev.Id = head[:7]
+	rev.Date = c.Date
+	rev.Author = c.Author
+	return rev, nil
+}
+
+func (g git) Root(dir string) (string, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	return r.workTree, nil
+}
+
+func (g git) URL(dir string) (string, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	return r.Config("remote.origin.url")
+}
+
+func (g git) Branch(dir string) (string, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	ref, err := r.HeadRef()
+	return strings.TrimPrefix(ref, "refs/heads/"), err
+}
+
+func (g git) Tags(dir string) ([]gosrc.Tag, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := r.Refs("refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+	var tags []gosrc.Tag
+	for ref, hash := range refs {
+		commit, date, err := r.Peel(hash)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", ref, err)
+		}
+		if date.IsZero() {
+			// A lightweight tag, dated by its commit.
+			c, err := r.Commit(commit)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", ref, err)
+			}
+			date = c.Date
+		}
+		tags = append(tags, gosrc.NewTag(strings.TrimPrefix(ref, "refs/tags/"), commit[:7], date))
+	}
+	sort.Sort(byName(tags))
+	return tags, nil
+}
+
+func (g git) Ahead(dir string, tag gosrc.Tag) (int, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return 0, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return 0, err
+	}
+	ref, err := r.Ref("refs/tags/" + tag.Name)
+	if err != nil {
+		return 0, err
+	}
+	base, _, err := r.Peel(ref)
+	if err != nil {
+		return 0, err
+	}
+	released, err := r.Walk(base, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	ahead, err := r.Walk(head, released, nil)
+	return len(ahead), err
 }
 
-func (g git) Root(dir string) string {
-	return vcsCmd(dir, "git", "rev-parse", "--show-toplevel")
+func (g git) History(dir string) ([]gosrc.Revision, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return nil, fmt.Errorf("reading HEAD: %s", err)
+	}
+	var revs []gosrc.Revision
+	_, err = r.Walk(head, nil, func(c gitCommitInfo) {
+		revs = append(revs, gosrc.Revision{Id: c.Hash[:7], Author: c.Author, Date: c.Date})
+	})
+	return revs, err
 }
 
-func (g git) URL(dir string) string {
-	return vcsCmd(dir, "git", "config", "--get", "remote.origin.url")
+func (g git) Checkout(root, rev string) error {
+	_, err := runVCS([]string{root}, root, "", "git", "checkout", "-q", rev)
+	return err
 }
 
+type byName []gosrc.Tag
+
+func (t byName) Len() int           { return len(t) }
+func (t byName) Less(i, j int) bool { return t[i].Name < t[j].Name }
+func (t byName) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
 type hg struct {
 }
 
+const hgNotRepo = "no repository found"
+
 func (h hg) Name() string {
 	return "hg"
 }
 
-func (h hg) Revision(dir string) gosrc.Revision {
-	s := vcsCmd(dir, "hg", "log", "-r", ".", "--template", "{node|short}\n{date|isodatesec}\n{author}")
+func (h hg) Revision(dir string) (gosrc.Revision, error) {
+	s, err := vcsCmd(dir, hgNotRepo, "hg", "log", "-r", ".", "--template", "{node|short}\n{date|isodatesec}\n{author}")
+	if err != nil {
+		return gosrc.Revision{}, err
+	}
 	return parseRevision(s)
 }
 
-func (h hg) Root(dir string) string {
-	return vcsCmd(dir, "hg", "root")
+func (h hg) Root(dir string) (string, error) {
+	return vcsCmd(dir, hgNotRepo, "hg", "root")
+}
+
+func (h hg) URL(dir string) (string, error) {
+	url, err := vcsCmd(dir, hgNotRepo, "hg", "paths", "default")
+	if err != nil && strings.Contains(err.Error(), "not found!") {
+		// No default path is configured.
+		return "", nil
+	}
+	return url, err
+}
+
+func (h hg) Branch(dir string) (string, error) {
+	return vcsCmd(dir, hgNotRepo, "hg", "branch")
+}
+
+func (h hg) Tags(dir string) ([]gosrc.Tag, error) {
+	s, err := vcsCmd(dir, hgNotRepo, "hg", "log", "-r", "tag()", "--template", "{tags}\t{node|short}\t{date|isodatesec}\n")
+	if err != nil {
+		return nil, err
+	}
+	return parseHgTags(s)
+}
+
+func (h hg) Ahead(dir string, tag gosrc.Tag) (int, error) {
+	revset := fmt.Sprintf("only(., tag(%q))", "literal:"+tag.Name)
+	s, err := vcsCmd(dir, hgNotRepo, "hg", "log", "-r", revset, "--template", "x")
+	return len(s), err
+}
+
+func (h hg) History(dir string) ([]gosrc.Revision, error) {
+	s, err := vcsCmd(dir, hgNotRepo, "hg", "log", "-r", "::.", "--template", "{node|short}\t{date|isodatesec}\t{author}\n")
+	if err != nil {
+		return nil, err
+	}
+	return parseHgHistory(s)
+}
+
+func (h hg) Checkout(root, rev string) error {
+	_, err := runVCS([]string{root}, root, hgNotRepo, "hg", "update", "-r", rev)
+	return err
+}
+
+// parseHgHistory parses lines of "node<TAB>date<TAB>author".
+func parseHgHistory(s string) ([]gosrc.Revision, error) {
+	var revs []gosrc.Revision
+	for _, l := range strings.Split(s, "\n") {
+		if l == "" {
+			continue
+		}
+		rev, err := parseRevision(strings.Replace(l, "\t", "\n", 2))
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	return revs, nil
 }
 
-func (h hg) URL(dir string) string {
-	return vcsCmd(dir, "hg", "paths", "default")
+// parseHgTags parses lines of "tags<TAB>node<TAB>date", where tags is a
+// space separated list.
+func parseHgTags(s string) ([]gosrc.Tag, error) {
+	var tags []gosrc.Tag
+	for _, l := range strings.Split(s, "\n") {
+		if l == "" {
+			continue
+		}
+		parts := strings.Split(l, "\t")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("malformed tag line %q", l)
+		}
+		d, err := time.Parse(iso8601Date, parts[2])
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range strings.Fields(parts[0]) {
+			if name != "tip" {
+				tags = append(tags, gosrc.NewTag(name, parts[1], d))
+			}
+		}
+	}
+	return tags, nil
 }
 
 type bzr struct {
 }
 
+const bzrNotRepo = "Not a branch"
+
 func (b bzr) Name() string {
 	return "bzr"
 }
 
-func (b bzr) Revision(dir string) gosrc.Revision {
+func (b bzr) Revision(dir string) (gosrc.Revision, error) {
 	//stupid bzr and its non-customizable output
-	s := vcsCmd(dir, "bzr", "log", "--limit=1", "--log-format=long")
+	s, err := vcsCmd(dir, bzrNotRepo, "bzr", "log", "--limit=1", "--log-format=long")
+	if err != nil {
+		return gosrc.Revision{}, err
+	}
 	return parseBzrRevision(s)
 }
 
-func (b bzr) Root(dir string) string {
-	return vcsCmd(dir, "bzr", "root")
+func (b bzr) Root(dir string) (string, error) {
+	return vcsCmd(dir, bzrNotRepo, "bzr", "root")
+}
+
+func (b bzr) URL(dir string) (string, error) {
+	s, err := vcsCmd(dir, bzrNotRepo, "bzr", "info")
+	if err != nil {
+		return "", err
+	}
+	return parseBzrURL(s), nil
+}
+
+func (b bzr) Branch(dir string) (string, error) {
+	return vcsCmd(dir, bzrNotRepo, "bzr", "nick")
+}
+
+func (b bzr) Tags(dir string) ([]gosrc.Tag, error) {
+	s, err := vcsCmd(dir, bzrNotRepo, "bzr", "tags")
+	if err != nil {
+		return nil, err
+	}
+	tags := parseBzrTags(s)
+	for i, t := range tags {
+		s, err := vcsCmd(dir, bzrNotRepo, "bzr", "log", "-r", t.Revision, "--log-format=long")
+		if err != nil {
+			return nil, err
+		}
+		rev, err := parseBzrRevision(s)
+		if err != nil {
+			return nil, err
+		}
+		tags[i].Date = rev.Date
+	}
+	return tags, nil
+}
+
+func (b bzr) Ahead(dir string, tag gosrc.Tag) (int, error) {
+	s, err := vcsCmd(dir, bzrNotRepo, "bzr", "revno")
+	if err != nil {
+		return 0, err
+	}
+	head, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bzr revno: %s", err)
+	}
+	// Tags on merged revisions have dotted revnos, which can't be compared.
+	base, err := strconv.Atoi(tag.Revision)
+	if err != nil {
+		return 0, fmt.Errorf("tag %s has revno %s", tag.Name, tag.Revision)
+	}
+	return head - base, nil
+}
+
+func (b bzr) History(dir string) ([]gosrc.Revision, error) {
+	s, err := vcsCmd(dir, bzrNotRepo, "bzr", "log", "--levels=1", "--log-format=long")
+	if err != nil {
+		return nil, err
+	}
+	return parseBzrHistory(s)
+}
+
+func (b bzr) Checkout(root, rev string) error {
+	_, err := runVCS([]string{root}, root, bzrNotRepo, "bzr", "update", "-r", rev)
+	return err
+}
+
+// bzrSeparator separates the entries of bzr's long log format.
+const bzrSeparator = "------------------------------------------------------------"
+
+func parseBzrHistory(s string) ([]gosrc.Revision, error) {
+	var revs []gosrc.Revision
+	for _, entry := range strings.Split(s, bzrSeparator) {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		rev, err := parseBzrRevision(entry)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	return revs, nil
 }
 
-func (b bzr) URL(dir string) string {
+// bzrLocations are the `bzr info` locations that can serve as the URL of a
+// branch, in order of preference.
+var bzrLocations = []string{
+	"checkout of branch",
+	"bound to branch",
+	"parent branch",
+	"push branch",
+	"submit branch",
+}
+
+func parseBzrURL(s string) string {
+	info := parseInfo(s)
+	for _, l := range bzrLocations {
+		if url := info[l]; url != "" {
+			return url
+		}
+	}
 	return ""
 }
 
+func parseBzrTags(s string) []gosrc.Tag {
+	var tags []gosrc.Tag
+	for _, l := range strings.Split(s, "\n") {
+		// Tags outside the branch's ancestry have a revno of "?".
+		fields := strings.Fields(l)
+		if len(fields) == 2 && fields[1] != "?" {
+			tags = append(tags, gosrc.NewTag(fields[0], fields[1], time.Time{}))
+		}
+	}
+	return tags
+}
+
+type svn struct {
+}
+
+const svnNotRepo = "is not a working copy"
+
+func (s svn) Name() string {
+	return "svn"
+}
+
+func (s svn) Revision(dir string) (gosrc.Revision, error) {
+	out, err := vcsCmd(dir, svnNotRepo, "svn", "info")
+	if err != nil {
+		return gosrc.Revision{}, err
+	}
+	return parseSvnRevision(out)
+}
+
+func (s svn) Root(dir string) (string, error) {
+	out, err := vcsCmd(dir, svnNotRepo, "svn", "info")
+	if err != nil {
+		return "", err
+	}
+	return parseInfo(out)["Working Copy Root Path"], nil
+}
+
+func (s svn) URL(dir string) (string, error) {
+	root, err := s.Root(dir)
+	if err != nil || root == "" {
+		return "", err
+	}
+	out, err := vcsCmd(root, svnNotRepo, "svn", "info")
+	if err != nil {
+		return "", err
+	}
+	return parseInfo(out)["URL"], nil
+}
+
+func (s svn) Checkout(root, rev string) error {
+	_, err := runVCS([]string{root}, root, svnNotRepo, "svn", "update", "-q", "-r", rev)
+	return err
+}
+
+type fossil struct {
+}
+
+const fossilNotRepo = "use --repository"
+
+func (f fossil) Name() string {
+	return "fossil"
+}
+
+func (f fossil) Revision(dir string) (gosrc.Revision, error) {
+	out, err := vcsCmd(dir, fossilNotRepo, "fossil", "info")
+	if err != nil {
+		return gosrc.Revision{}, err
+	}
+	return parseFossilRevision(out)
+}
+
+func (f fossil) Root(dir string) (string, error) {
+	out, err := vcsCmd(dir, fossilNotRepo, "fossil", "info")
+	if err != nil {
+		return "", err
+	}
+	root := parseInfo(out)["local-root"]
+	if len(root) > 1 {
+		root = strings.TrimSuffix(root, "/")
+	}
+	return root, nil
+}
+
+func (f fossil) URL(dir string) (string, error) {
+	url, err := vcsCmd(dir, fossilNotRepo, "fossil", "remote-url")
+	if url == "off" {
+		return "", err
+	}
+	return url, err
+}
+
+func (f fossil) Checkout(root, rev string) error {
+	_, err := runVCS([]string{root}, root, fossilNotRepo, "fossil", "update", rev)
+	return err
+}
+
 var (
 	Git    = git{}
 	Hg     = hg{}
 	Bzr    = bzr{}
-	AllVCS = []VCS{Git, Hg, Bzr}
+	Svn    = svn{}
+	Fossil = fossil{}
+	AllVCS = []VCS{Git, Hg, Bzr, Svn, Fossil}
 )
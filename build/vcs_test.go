@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/kisielk/gosrc"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -15,7 +16,10 @@ func TestParseRevision(t *testing.T) {
 2014-05-26 15:30:45 -0700
 Kamil Kisiel <kamil@kamilkisiel.net>`
 
-	rev := parseRevision(testRev)
+	rev, err := parseRevision(testRev)
+	if err != nil {
+		t.Fatal(err)
+	}
 	date, _ := time.Parse(iso8601Date, "2014-05-26 15:30:45 -0700")
 	expectedRev := gosrc.Revision{
 		Id:     "1234",
@@ -37,7 +41,10 @@ message:
 	Add a package doc.
 `
 
-	rev := parseBzrRevision(bzrRev)
+	rev, err := parseBzrRevision(bzrRev)
+	if err != nil {
+		t.Fatal(err)
+	}
 	date, _ := time.Parse(bzrDate, "Tue 2013-07-16 19:19:43 -0300")
 	expectedRev := gosrc.Revision{
 		Id:     "4",
@@ -48,3 +55,244 @@ message:
 		t.Fatalf("got %+v, want %+v", rev, expectedRev)
 	}
 }
+
+func TestParseSvnRevision(t *testing.T) {
+	date, _ := time.Parse(iso8601Date, "2014-05-26 15:30:45 -0700")
+	var tests = []struct {
+		info string
+		rev  gosrc.Revision
+	}{
+		{
+			`Path: .
+Working Copy Root Path: /tmp/gopath/src/example.com/svn/repo
+URL: https://example.com/svn/repo/trunk
+Relative URL: ^/trunk
+Repository Root: https://example.com/svn/repo
+Repository UUID: 0b8c6a5e-2a4c-4c6e-a1d1-6f0e3f1b2c3d
+Revision: 1310
+Node Kind: directory
+Schedule: normal
+Last Changed Author: kamil
+Last Changed Rev: 1303
+Last Changed Date: 2014-05-26 15:30:45 -0700 (Mon, 26 May 2014)
+`,
+			gosrc.Revision{Id: "1303", Author: "kamil", Date: date},
+		},
+	}
+	for _, test := range tests {
+		rev, err := parseSvnRevision(test.info)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !revEqual(rev, test.rev) {
+			t.Errorf("got %+v, want %+v", rev, test.rev)
+		}
+	}
+	if _, err := parseSvnRevision(""); err == nil {
+		t.Error("expected an error for empty output")
+	}
+}
+
+func TestParseFossilRevision(t *testing.T) {
+	date, _ := time.Parse(fossilDate, "2014-05-26 22:30:45 UTC")
+	var tests = []struct {
+		info string
+		rev  gosrc.Revision
+	}{
+		{
+			`project-name: Fossil
+repository:   /home/kamil/fossil.fossil
+local-root:   /tmp/gopath/src/example.com/fossil/
+config-db:    /home/kamil/.fossil
+project-code: CE59BB9F186226D80E49D1FA2DB29F935CCA0333
+checkout:     3e5975aa3bb1df18e14845a1e1c5a1cbac3a3d7c 2014-05-26 22:30:45 UTC
+parent:       1e1a1a5c3f8d6c7b4a3b2c1d0e9f8a7b6c5d4e3f 2014-05-25 10:00:00 UTC
+tags:         trunk
+comment:      Fix the (broken) build. (user: drh)
+check-ins:    8123
+`,
+			gosrc.Revision{Id: "3e5975aa3b", Author: "drh", Date: date},
+		},
+	}
+	for _, test := range tests {
+		rev, err := parseFossilRevision(test.info)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !revEqual(rev, test.rev) {
+			t.Errorf("got %+v, want %+v", rev, test.rev)
+		}
+	}
+	if _, err := parseFossilRevision(""); err == nil {
+		t.Error("expected an error for empty output")
+	}
+}
+
+func TestParseInfo(t *testing.T) {
+	info := parseInfo(`Working Copy Root Path: /tmp/wc
+URL: https://example.com/svn/repo/trunk
+local-root:   /tmp/fossil/
+`)
+	var tests = []struct {
+		key, value string
+	}{
+		{"Working Copy Root Path", "/tmp/wc"},
+		{"URL", "https://example.com/svn/repo/trunk"},
+		{"local-root", "/tmp/fossil/"},
+		{"missing", ""},
+	}
+	for _, test := range tests {
+		if got := info[test.key]; got != test.value {
+			t.Errorf("info[%q] = %q, want %q", test.key, got, test.value)
+		}
+	}
+}
+
+func TestParseRevisionErrors(t *testing.T) {
+	var tests = []string{
+		"",
+		"1234\n2014-05-26\nKamil Kisiel <kamil@kamilkisiel.net>",
+	}
+	for _, test := range tests {
+		if _, err := parseRevision(test); err == nil {
+			t.Errorf("parseRevision(%q): expected an error", test)
+		}
+	}
+}
+
+func TestParseBzrURL(t *testing.T) {
+	var tests = []struct {
+		info string
+		url  string
+	}{
+		{
+			`Standalone tree (format: 2a)
+Location:
+  branch root: .
+
+Related branches:
+    parent branch: http://bazaar.launchpad.net/~niemeyer/twik/trunk/
+      push branch: bzr+ssh://bazaar.launchpad.net/~niemeyer/twik/trunk/
+`,
+			"http://bazaar.launchpad.net/~niemeyer/twik/trunk/",
+		},
+		{
+			`Checkout (format: 2a)
+Location:
+       checkout root: .
+  checkout of branch: bzr+ssh://bazaar.launchpad.net/+branch/goyaml/
+
+Related branches:
+  parent branch: http://bazaar.launchpad.net/~goyaml/goyaml/trunk/
+`,
+			"bzr+ssh://bazaar.launchpad.net/+branch/goyaml/",
+		},
+		{
+			`Standalone tree (format: 2a)
+Location:
+  branch root: .
+
+Related branches:
+  push branch: lp:~niemeyer/twik/trunk
+`,
+			"lp:~niemeyer/twik/trunk",
+		},
+		{
+			`Standalone tree (format: 2a)
+Location:
+  branch root: .
+`,
+			"",
+		},
+	}
+	for _, test := range tests {
+		if url := parseBzrURL(test.info); url != test.url {
+			t.Errorf("got %q, want %q", url, test.url)
+		}
+	}
+}
+
+func TestParseBzrTags(t *testing.T) {
+	tags := parseBzrTags(`v0.1                 3
+v0.2                 4
+experiment           ?
+`)
+	want := []gosrc.Tag{gosrc.NewTag("v0.1", "3", time.Time{}), gosrc.NewTag("v0.2", "4", time.Time{})}
+	if !reflect.DeepEqual(tags, want) {
+		t.Fatalf("got %+v, want %+v", tags, want)
+	}
+}
+
+func TestParseHgTags(t *testing.T) {
+	s := "v1.0\t1a2b3c4d5e6f\t2014-05-26 15:30:45 -0700\n" +
+		"tip v1.1 stable\t0f1e2d3c4b5a\t2014-06-01 09:00:00 +0200\n"
+	tags, err := parseHgTags(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d1, _ := time.Parse(iso8601Date, "2014-05-26 15:30:45 -0700")
+	d2, _ := time.Parse(iso8601Date, "2014-06-01 09:00:00 +0200")
+	want := []gosrc.Tag{
+		gosrc.NewTag("v1.0", "1a2b3c4d5e6f", d1),
+		gosrc.NewTag("v1.1", "0f1e2d3c4b5a", d2),
+		gosrc.NewTag("stable", "0f1e2d3c4b5a", d2),
+	}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("got %+v, want %+v", tags, want)
+	}
+	if _, err := parseHgTags("v1.0\t1a2b3c4d5e6f\n"); err == nil {
+		t.Error("expected error for malformed line")
+	}
+}
+
+func TestParseHgHistory(t *testing.T) {
+	s := "1a2b3c4d5e6f\t2014-05-26 15:30:45 -0700\tKamil Kisiel <kamil@kamilkisiel.net>\n" +
+		"0f1e2d3c4b5a\t2014-05-25 10:00:00 -0700\tSomeone Else <else@example.com>\n"
+	revs, err := parseHgHistory(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revs))
+	}
+	date, _ := time.Parse(iso8601Date, "2014-05-25 10:00:00 -0700")
+	want := gosrc.Revision{Id: "0f1e2d3c4b5a", Author: "Someone Else <else@example.com>", Date: date}
+	if !revEqual(revs[1], want) {
+		t.Errorf("got %+v, want %+v", revs[1], want)
+	}
+	if _, err := parseHgHistory("1a2b3c4d5e6f\tyesterday\tKamil\n"); err == nil {
+		t.Error("expected error for malformed date")
+	}
+}
+
+func TestParseBzrHistory(t *testing.T) {
+	s := `------------------------------------------------------------
+revno: 2
+committer: Gustavo Niemeyer <gustavo@niemeyer.net>
+branch nick: twik
+timestamp: Tue 2013-07-16 19:19:43 -0300
+message:
+  Add a package doc.
+------------------------------------------------------------
+revno: 1
+committer: Someone Else <else@example.com>
+branch nick: twik
+timestamp: Mon 2013-07-15 10:00:00 -0300
+message:
+  Initial commit.
+`
+	revs, err := parseBzrHistory(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revs))
+	}
+	date, _ := time.Parse(bzrDate, "Mon 2013-07-15 10:00:00 -0300")
+	want := gosrc.Revision{Id: "1", Author: "Someone Else <else@example.com>", Date: date}
+	if !revEqual(revs[1], want) {
+		t.Errorf("got %+v, want %+v", revs[1], want)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kisielk/gosrc"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errCrawlDone is returned when asking for work from a coordinator whose
+// crawl has finished.
+var errCrawlDone = errors.New("crawl finished")
+
+// runWorkers takes packages from the coordinator at url and downloads and
+// builds them with n concurrent workers. It returns when the coordinator
+// reports that the crawl has finished.
+func runWorkers(url, gopath string, n int) {
+	url = strings.TrimRight(url, "/")
+	fetched := newRepoSet()
+	limiter := newHostLimiter(*hostDownloads, *hostDelay)
+	resolver := newResolver()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker(url, gopath, fetched, limiter, resolver)
+		}()
+	}
+	wg.Wait()
+	log.Println("crawl finished")
+}
+
+// workerGOPATH returns the GOPATH for this worker process, and a function
+// to remove it once the worker is done. Other worker processes on the same
+// machine may be downloading at the same time, so the default GOPATH is
+// replaced with a temporary one unless -gopath is given.
+func workerGOPATH() (string, func(), error) {
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "gopath" {
+			explicit = true
+		}
+	})
+	if explicit {
+		dir, err := filepath.Abs(*gopath)
+		return dir, func() {}, err
+	}
+	dir, err := ioutil.TempDir("", "gosrc-worker")
+	if err != nil {
+		return "", nil, err
+	}
+	log.Println("using GOPATH", dir)
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+func worker(url, gopath string, fetched *repoSet, limiter *hostLimiter, resolver *gosrc.Resolver) {
+	for {
+		work, ok, err := requestWork(url)
+		if err == errCrawlDone {
+			return
+		}
+		if err != nil {
+			log.Println("failed to get work:", err)
+			time.Sleep(*retryDelay)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		res := doWork(gopath, fetched, limiter, resolver, work)
+		for {
+			err := postResult(url, res)
+			if err == nil {
+				break
+			}
+			log.Println(work.Path, "failed to post result:", err)
+			time.Sleep(*retryDelay)
+		}
+	}
+}
+
+// doWork downloads and builds the package described by work.
+func doWork(gopath string, fetched *repoSet, limiter *hostLimiter, resolver *gosrc.Resolver, work workItem) workResult {
+	res := workResult{Path: work.Path, Depth: work.Depth}
+
+	attempts, root, err := fetch(gopath, fetched, limiter, resolver, work.Path)
+	res.Attempts = attempts
+	if err != nil {
+		log.Println(work.Path, "failed to download:", err)
+		res.DownloadError = err.Error()
+		res.Transient = isTransient(err)
+		return res
+	}
+	log.Println(work.Path, "downloaded")
+	res.Root, res.Packages = root, repoPackages(gopath, root)
+
+	if unchanged(gopath, work.Path, work.Options.Revision, work.Revision, work.GoVersion) {
+		log.Println(work.Path, "unchanged, skipping build")
+		res.Cached = true
+		return res
+	}
+	res.Package = buildPackage(gopath, work.Path, work.Options)
+	return res
+}
+
+// requestWork asks the coordinator for a package. It returns false if
+// the coordinator had nothing to hand out, and errCrawlDone if it never
+// will again.
+func requestWork(url string) (workItem, bool, error) {
+	var work workItem
+	resp, err := http.Post(url+workPath, "application/json", nil)
+	if err != nil {
+		return work, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		err := json.NewDecoder(resp.Body).Decode(&work)
+		return work, err == nil, err
+	case http.StatusNoContent:
+		return work, false, nil
+	case workDoneStatus:
+		return work, false, errCrawlDone
+	default:
+		return work, false, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+}
+
+func postResult(url string, res workResult) error {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(url+resultPath, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
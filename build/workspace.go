@@ -0,0 +1,243 @@
+package main
+
+import (
+	"github.com/kisielk/gosrc"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// gopathLock guards the shared GOPATH. Downloads hold it for reading, since
+// they may run concurrently with each other, and snapshots hold it for
+// writing so that they see no download in progress.
+var gopathLock sync.RWMutex
+
+// buildPackage builds pkg in the shared GOPATH, or in a workspace of its own
+// if -isolate is set or pkg is pinned to a revision. Pinned revisions are
+// only checked out in the workspace, so that the shared GOPATH stays on the
+// branch go get updates, and other packages in the repository aren't built
+// at the pinned revision.
+func buildPackage(gopath, pkg string, options gosrc.ListEntry) gosrc.Package {
+	pinned := options.Revision != ""
+	if !*isolate && !pinned {
+		return getPackage(gopath, pkg, options)
+	}
+
+	workspace, err := ioutil.TempDir("", "gosrc-workspace")
+	if err != nil {
+		log.Println(pkg, "failed to create workspace:", err)
+		if pinned {
+			return unbuiltPackage(pkg, options, "failed to create workspace: "+err.Error())
+		}
+		return getPackage(gopath, pkg, options)
+	}
+	defer os.RemoveAll(workspace)
+
+	log.Println(pkg, "creating workspace", workspace)
+	if err := snapshot(gopath, workspace, pkg); err != nil {
+		log.Println(pkg, "failed to create workspace:", err)
+		if pinned {
+			return unbuiltPackage(pkg, options, "failed to create workspace: "+err.Error())
+		}
+		return getPackage(gopath, pkg, options)
+	}
+	if pinned {
+		log.Println(pkg, "checking out", options.Revision)
+		if err := checkout(filepath.Join(workspace, "src", pkg), options.Revision); err != nil {
+			log.Println(pkg, "failed to check out", options.Revision+":", err)
+			return unbuiltPackage(pkg, options, "failed to check out "+options.Revision+": "+err.Error())
+		}
+	}
+	return getPackage(workspace, pkg, options)
+}
+
+// unbuiltPackage returns the results for pkg when it could not be set up to
+// be built, with msg as the build log.
+func unbuiltPackage(pkg string, options gosrc.ListEntry, msg string) gosrc.Package {
+	p := newPackage(pkg, options)
+	p.Build.Log = msg
+	return p
+}
+
+// snapshot copies the repositories of pkg and of the packages it depends on
+// from gopath to workspace.
+func snapshot(gopath, workspace, pkg string) error {
+	gopathLock.Lock()
+	defer gopathLock.Unlock()
+
+	roots := []string{repositoryRoot(getRepository(gopath, pkg), pkg)}
+	for _, dep := range dependencyRepositories(gopath, pkg) {
+		roots = append(roots, dep.Repository.Root)
+	}
+	for _, root := range roots {
+		src := filepath.Join(gopath, "src", root)
+		dst := filepath.Join(workspace, "src", root)
+		if err := copyDir(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dependencyRepositories returns the packages outside the standard library
+// that pkg depends on, grouped by the repository they belong to, with the
+// revisions currently in gopath.
+func dependencyRepositories(gopath, pkg string) []gosrc.Dependency {
+	own := repositoryRoot(getRepository(gopath, pkg), pkg)
+	index := map[string]int{own: -1} // root to position in deps
+	var deps []gosrc.Dependency
+	for _, imp := range dependencies(gopath, pkg) {
+		if root := containingRoot(index, imp); root != "" {
+			if i := index[root]; i >= 0 {
+				deps[i].Packages = append(deps[i].Packages, imp)
+			}
+			continue
+		}
+		repo := getRepository(gopath, imp)
+		repo.Root = repositoryRoot(repo, imp)
+		index[repo.Root] = len(deps)
+		deps = append(deps, gosrc.Dependency{Repository: repo, Packages: []string{imp}})
+	}
+	sort.Sort(byRoot(deps))
+	return deps
+}
+
+// repositoryRoot returns the root of repo relative to GOPATH/src, or pkg if
+// the package isn't in a repository within the GOPATH.
+func repositoryRoot(repo gosrc.Repository, pkg string) string {
+	if repo.Root == "" || filepath.IsAbs(repo.Root) || strings.HasPrefix(repo.Root, "..") {
+		return pkg
+	}
+	return filepath.ToSlash(repo.Root)
+}
+
+// containingRoot returns the root in roots that contains pkg, or "".
+func containingRoot(roots map[string]int, pkg string) string {
+	for p := pkg; p != "."; p = path.Dir(p) {
+		if _, ok := roots[p]; ok {
+			return p
+		}
+	}
+	return ""
+}
+
+type byRoot []gosrc.Dependency
+
+func (r byRoot) Len() int           { return len(r) }
+func (r byRoot) Less(i, j int) bool { return r[i].Repository.Root < r[j].Repository.Root }
+func (r byRoot) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// dependencies returns the packages outside the standard library imported by
+// pkg or its tests, directly or indirectly, that can be found in gopath.
+func dependencies(gopath, pkg string) []string {
+	ctx := build.Default
+	ctx.GOPATH = gopath
+
+	seen := map[string]bool{pkg: true}
+	var deps []string
+	var visit func(p string, tests bool)
+	visit = func(p string, tests bool) {
+		bp, err := ctx.Import(p, "", 0)
+		if err != nil {
+			return
+		}
+		imports := bp.Imports
+		if tests {
+			imports = append(append(imports, bp.TestImports...), bp.XTestImports...)
+		}
+		for _, imp := range imports {
+			if seen[imp] || imp == "C" {
+				continue
+			}
+			seen[imp] = true
+			if dep, err := ctx.Import(imp, "", build.FindOnly); err != nil || dep.Goroot {
+				continue
+			}
+			deps = append(deps, imp)
+			visit(imp, false)
+		}
+	}
+	visit(pkg, true)
+	sort.Strings(deps)
+	return deps
+}
+
+// copyDir recursively copies the directory src to dst. Symbolic links that
+// point within src are rewritten as relative links within dst, and those
+// that point outside it, which could reach into the shared GOPATH, are left
+// out.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm()|0700)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := copyLink(src, dst, file)
+			if err != nil {
+				return err
+			}
+			if link == "" {
+				log.Println(file, "links outside", src+", not copying it")
+				return nil
+			}
+			return os.Symlink(link, target)
+		case fi.Mode().IsRegular():
+			return copyFile(file, target, fi.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// copyLink returns the target for the copy in dst of the symbolic link file
+// in src, relative to the copy, or "" if the link points outside src.
+func copyLink(src, dst, file string) (string, error) {
+	link, err := os.Readlink(file)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(link) {
+		link = filepath.Join(filepath.Dir(file), link)
+	}
+	rel, err := filepath.Rel(src, link)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", nil
+	}
+	fileRel, err := filepath.Rel(src, file)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(filepath.Dir(filepath.Join(dst, fileRel)), filepath.Join(dst, rel))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	// Resolve imports in the temporary GOPATH rather than as modules.
+	defer os.Setenv("GO111MODULE", os.Getenv("GO111MODULE"))
+	os.Setenv("GO111MODULE", "off")
+
+	gopath, err := ioutil.TempDir("", "gosrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	writeFiles(t, gopath, map[string]string{
+		"src/example.com/a/a.go":      "package a\nimport (\n\t\"fmt\"\n\t\"example.com/b\"\n)\n",
+		"src/example.com/a/a_test.go": "package a\nimport \"example.com/t\"\n",
+		"src/example.com/b/b.go":      "package b\nimport \"example.com/c/d\"\n",
+		"src/example.com/c/d/d.go":    "package d\n",
+		"src/example.com/t/t.go":      "package t\n",
+		"src/example.com/u/u.go":      "package u\n",
+	})
+
+	want := []string{"example.com/b", "example.com/c/d", "example.com/t"}
+	if got := dependencies(gopath, "example.com/a"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("dependencies: got %v, want %v", got, want)
+	}
+
+	var roots []string
+	for _, dep := range dependencyRepositories(gopath, "example.com/a") {
+		roots = append(roots, dep.Repository.Root)
+		if len(dep.Packages) != 1 || dep.Packages[0] != dep.Repository.Root {
+			t.Errorf("got packages %v for unversioned dependency %s", dep.Packages, dep.Repository.Root)
+		}
+	}
+	if !reflect.DeepEqual(roots, want) {
+		t.Fatalf("dependencyRepositories: got roots %v, want %v", roots, want)
+	}
+
+	workspace := filepath.Join(gopath, "workspace")
+	if err := snapshot(gopath, workspace, "example.com/a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a/a.go", "a/a_test.go", "b/b.go", "c/d/d.go", "t/t.go"} {
+		if _, err := os.Stat(filepath.Join(workspace, "src/example.com", name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "src/example.com/u")); !os.IsNotExist(err) {
+		t.Error("unrelated package was copied to the workspace")
+	}
+}
+
+func TestCopyDirLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	writeFiles(t, dir, map[string]string{
+		"src/sub/file.go": "package sub\n",
+		"secret":          "secret\n",
+	})
+	links := map[string]string{
+		"src/rel":      "sub/file.go",
+		"src/sub/up":   "../sub",
+		"src/abs":      filepath.Join(src, "sub"),
+		"src/escape":   "../secret",
+		"src/absolute": filepath.Join(dir, "secret"),
+	}
+	for name, link := range links {
+		if err := os.Symlink(link, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := filepath.Join(dir, "dst")
+	if err := copyDir(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name string
+		link string // "" if the link should not be copied
+	}{
+		{"rel", "sub/file.go"},
+		{"sub/up", "."},
+		{"abs", "sub"},
+		{"escape", ""},
+		{"absolute", ""},
+	}
+	for _, test := range tests {
+		link, err := os.Readlink(filepath.Join(dst, test.name))
+		if test.link == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s: got link %q, want none", test.name, link)
+			}
+			continue
+		}
+		if err != nil || link != filepath.FromSlash(test.link) {
+			t.Errorf("%s: got link %q, %v, want %q", test.name, link, err, test.link)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "abs/file.go")); err != nil {
+		t.Error(err)
+	}
+}
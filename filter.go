@@ -0,0 +1,191 @@
+package gosrc
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter decides which import paths are within the scope of a crawl.
+//
+// Patterns are globs matched against the whole import path, in which *
+// matches within a path element and "..." matches anything, as with the go
+// tool. Patterns prefixed with "re:" are regular expressions, matched
+// anywhere in the path unless anchored.
+type Filter struct {
+	// If Include is not empty, only paths matching one of its patterns
+	// are in scope.
+	Include []string
+	// Paths matching a pattern in Exclude are out of scope.
+	Exclude []string
+
+	// If AllowHosts is not empty, only paths on one of its hosts, or their
+	// subdomains, are in scope.
+	AllowHosts []string
+	// Paths on a host in DenyHosts, or its subdomains, are out of scope.
+	DenyHosts []string
+
+	// Skip paths with a vendor, internal, testdata or example(s) element.
+	SkipVendor   bool
+	SkipInternal bool
+	SkipTestdata bool
+	SkipExamples bool
+
+	include, exclude []*regexp.Regexp
+}
+
+// Compile checks the patterns of f. It must be called before Reject.
+func (f *Filter) Compile() error {
+	var err error
+	if f.include, err = compilePatterns(f.Include); err != nil {
+		return err
+	}
+	f.exclude, err = compilePatterns(f.Exclude)
+	return err
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := compilePattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %s", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// compilePattern turns a filter pattern into a regular expression.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "re:") {
+		return regexp.Compile(pattern[len("re:"):])
+	}
+	return compileGlob(pattern), nil
+}
+
+// compileGlob turns a glob, in which * matches within a path element and
+// "..." matches anything, into a regular expression matching whole paths.
+func compileGlob(pattern string) *regexp.Regexp {
+	var re []string
+	for i, part := range strings.Split(pattern, "...") {
+		if i > 0 {
+			re = append(re, ".*")
+		}
+		for j, glob := range strings.Split(part, "*") {
+			if j > 0 {
+				re = append(re, "[^/]*")
+			}
+			for _, r := range glob {
+				if r == '?' {
+					re = append(re, "[^/]")
+				} else {
+					re = append(re, regexp.QuoteMeta(string(r)))
+				}
+			}
+		}
+	}
+	s := strings.Join(re, "")
+	if strings.HasSuffix(s, "/.*") {
+		// As with the go tool, x/... matches x itself.
+		s = strings.TrimSuffix(s, "/.*") + "(/.*)?"
+	}
+	return regexp.MustCompile("^" + s + "$")
+}
+
+// Reject returns why importPath is out of scope, or "" if it is in scope.
+func (f *Filter) Reject(importPath string) string {
+	host := strings.SplitN(importPath, "/", 2)[0]
+	if len(f.AllowHosts) > 0 && !onHost(host, f.AllowHosts) {
+		return "host " + host + " is not allowed"
+	}
+	if onHost(host, f.DenyHosts) {
+		return "host " + host + " is denied"
+	}
+	for _, elem := range strings.Split(importPath, "/")[1:] {
+		switch {
+		case f.SkipVendor && elem == "vendor":
+			return "vendored"
+		case f.SkipInternal && elem == "internal":
+			return "internal"
+		case f.SkipTestdata && elem == "testdata":
+			return "in testdata"
+		case f.SkipExamples && (elem == "example" || elem == "examples"):
+			return "an example"
+		}
+	}
+	if len(f.include) > 0 && !matchAny(f.include, importPath) {
+		return "not included"
+	}
+	if matchAny(f.exclude, importPath) {
+		return "excluded"
+	}
+	return ""
+}
+
+// Apply returns the import paths in scope.
+func (f *Filter) Apply(paths []string) []string {
+	var in []string
+	for _, p := range paths {
+		if f.Reject(p) == "" {
+			in = append(in, p)
+		}
+	}
+	return in
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// onHost reports whether host is one of hosts or a subdomain of one.
+func onHost(host string, hosts []string) bool {
+	host = strings.ToLower(host)
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// StringList is a flag.Value for a flag that may be repeated, or given a
+// comma separated list.
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// FilterFlags defines the flags that configure a Filter in fs. After fs is
+// parsed, the returned function compiles and returns the Filter.
+func FilterFlags(fs *flag.FlagSet) func() (*Filter, error) {
+	f := new(Filter)
+	fs.Var((*StringList)(&f.Include), "include", "Only crawl import paths matching this pattern; may be repeated. Patterns are globs with ... or re:REGEXP")
+	fs.Var((*StringList)(&f.Exclude), "exclude", "Don't crawl import paths matching this pattern; may be repeated")
+	fs.Var((*StringList)(&f.AllowHosts), "allow-host", "Only crawl import paths on this host or its subdomains; may be repeated")
+	fs.Var((*StringList)(&f.DenyHosts), "deny-host", "Don't crawl import paths on this host or its subdomains; may be repeated")
+	fs.BoolVar(&f.SkipVendor, "skip-vendor", false, "Don't crawl vendored import paths")
+	fs.BoolVar(&f.SkipInternal, "skip-internal", false, "Don't crawl internal import paths")
+	fs.BoolVar(&f.SkipTestdata, "skip-testdata", false, "Don't crawl import paths in testdata directories")
+	fs.BoolVar(&f.SkipExamples, "skip-examples", false, "Don't crawl import paths in example or examples directories")
+	return func() (*Filter, error) {
+		return f, f.Compile()
+	}
+}
@@ -0,0 +1,87 @@
+package gosrc
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := &Filter{
+		Include:      []string{"github.com/kisielk/...", "example.com/*/lib", "re:^launchpad\\.net/"},
+		Exclude:      []string{"github.com/kisielk/old...", "re:/v[0-9]+$"},
+		DenyHosts:    []string{"launchpad.net"},
+		SkipVendor:   true,
+		SkipInternal: true,
+		SkipTestdata: true,
+		SkipExamples: true,
+	}
+	if err := f.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		path   string
+		reason string
+	}{
+		{"github.com/kisielk", ""},
+		{"github.com/kisielk/gosrc", ""},
+		{"github.com/kisielk/gosrc/build", ""},
+		{"github.com/kisielkx/gosrc", "not included"},
+		{"github.com/kisielk/oldstuff", "excluded"},
+		{"github.com/kisielk/gosrc/v2", "excluded"},
+		{"example.com/a/lib", ""},
+		{"example.com/a/b/lib", "not included"},
+		{"launchpad.net/twik", "host launchpad.net is denied"},
+		{"bazaar.launchpad.net/twik", "host bazaar.launchpad.net is denied"},
+		{"github.com/kisielk/gosrc/vendor/example.com/a", "vendored"},
+		{"github.com/kisielk/gosrc/internal/x", "internal"},
+		{"github.com/kisielk/gosrc/testdata/x", "in testdata"},
+		{"github.com/kisielk/gosrc/examples/hello", "an example"},
+	}
+	for _, test := range tests {
+		if got := f.Reject(test.path); got != test.reason {
+			t.Errorf("Reject(%q) = %q, want %q", test.path, got, test.reason)
+		}
+	}
+
+	allow := &Filter{AllowHosts: []string{"GitHub.com"}}
+	if err := allow.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	got := allow.Apply([]string{"github.com/a/b", "gist.github.com/1", "bitbucket.org/a/b"})
+	if want := []string{"github.com/a/b", "gist.github.com/1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply: got %q, want %q", got, want)
+	}
+
+	bad := &Filter{Exclude: []string{"re:("}}
+	if err := bad.Compile(); err == nil {
+		t.Error("expected error for bad regexp")
+	}
+}
+
+func TestFilterFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	filter := FilterFlags(fs)
+	err := fs.Parse([]string{
+		"-include", "github.com/...",
+		"-include", "example.com/a,example.com/b",
+		"-deny-host", "bitbucket.org",
+		"-skip-vendor",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := filter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"github.com/...", "example.com/a", "example.com/b"}; !reflect.DeepEqual(f.Include, want) {
+		t.Errorf("Include: got %q, want %q", f.Include, want)
+	}
+	if !reflect.DeepEqual(f.DenyHosts, []string{"bitbucket.org"}) || !f.SkipVendor || f.SkipInternal {
+		t.Errorf("got %+v", f)
+	}
+	if r := f.Reject("example.com/b"); r != "" {
+		t.Errorf("example.com/b rejected: %s", r)
+	}
+}
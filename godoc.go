@@ -0,0 +1,153 @@
+package gosrc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultGodocURL is the base URL of the godoc.org API.
+const DefaultGodocURL = "http://api.godoc.org"
+
+// DefaultGodocTimeout limits requests made by a GodocClient without its own
+// HTTP client. The package index is large, so this is generous.
+const DefaultGodocTimeout = 5 * time.Minute
+
+// GodocClient lists the packages in the index of a godoc.org API server or
+// mirror. The zero GodocClient uses godoc.org.
+type GodocClient struct {
+	// BaseURL is the URL of the API server. If empty, DefaultGodocURL is used.
+	BaseURL string
+
+	// Client is used for requests. If nil, a client with Timeout is used.
+	Client *http.Client
+
+	// Timeout limits requests when Client is nil. If zero,
+	// DefaultGodocTimeout is used.
+	Timeout time.Duration
+}
+
+// GodocPackages retrieves a list of packages in the godoc.org index.
+func GodocPackages() ([]string, error) {
+	var c GodocClient
+	return c.Packages()
+}
+
+// Packages retrieves the list of packages in the index.
+func (c *GodocClient) Packages() ([]string, error) {
+	var packages []string
+	err := c.Each(func(path string) error {
+		packages = append(packages, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return packages, nil
+}
+
+// Each calls fn with the import path of each package in the index as the
+// response is decoded, so that the whole index is never held in memory. It
+// stops at the first error returned by fn.
+func (c *GodocClient) Each(fn func(path string) error) error {
+	u := strings.TrimSuffix(c.baseURL(), "/") + "/packages"
+	resp, err := c.client().Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s: %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var fnErr error
+	err = decodeGodocPackages(resp.Body, func(path string) error {
+		fnErr = fn(path)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", u, err)
+	}
+	return nil
+}
+
+func (c *GodocClient) baseURL() string {
+	if c.BaseURL == "" {
+		return DefaultGodocURL
+	}
+	return c.BaseURL
+}
+
+func (c *GodocClient) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultGodocTimeout
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// decodeGodocPackages decodes a response of the form
+// {"results": [{"path": ...}, ...]} one result at a time.
+func decodeGodocPackages(r io.Reader, fn func(path string) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	found := false
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if key, _ := t.(string); key != "results" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+		found = true
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		for dec.More() {
+			var result struct {
+				Path string `json:"path"`
+			}
+			if err := dec.Decode(&result); err != nil {
+				return err
+			}
+			if err := fn(result.Path); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("no results in response")
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != d {
+		return fmt.Errorf("unexpected %v, expected %v", t, d)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package gosrc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGodocClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/packages":
+			fmt.Fprint(w, `{"results": [{"path": "example.com/a", "synopsis": "A"}, {"path": "example.com/b"}], "total": 2}`)
+		case "/broken/packages":
+			fmt.Fprint(w, `{"results": [{"path": "example.com/a"}, {"path": `)
+		case "/empty/packages":
+			fmt.Fprint(w, `{"error": "nothing here"}`)
+		case "/slow/packages":
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprint(w, `{"results": []}`)
+		default:
+			http.Error(w, "index unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	c := &GodocClient{BaseURL: ts.URL + "/"}
+	got, err := c.Packages()
+	if want := []string{"example.com/a", "example.com/b"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Packages: got %q, %v, want %q", got, err, want)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = c.Each(func(string) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("Each: got %v after %d calls, want %v after 1", err, n, stop)
+	}
+
+	var tests = []struct {
+		client *GodocClient
+		err    string
+	}{
+		{&GodocClient{BaseURL: ts.URL + "/down"}, "503 Service Unavailable: index unavailable"},
+		{&GodocClient{BaseURL: ts.URL + "/broken"}, "unexpected EOF"},
+		{&GodocClient{BaseURL: ts.URL + "/empty"}, "no results"},
+		{&GodocClient{BaseURL: ts.URL + "/slow", Timeout: 10 * time.Millisecond}, "Timeout"},
+	}
+	for _, test := range tests {
+		_, err := test.client.Packages()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.client.BaseURL, err, test.err)
+		}
+	}
+}
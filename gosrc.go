@@ -1,13 +1,15 @@
 package gosrc
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go/build"
 	"labix.org/v2/mgo"
-	"net/http"
-	"os"
+	"labix.org/v2/mgo/bson"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,7 +17,23 @@ type Repository struct {
 	Type     string
 	Revision Revision
 	Root     string
-	URL      string
+	Branch   string
+	Tags     []Tag
+
+	// URL is the canonical form of RawURL, the remote URL reported by the
+	// VCS without any credentials. See NormalizeURL and RedactURL.
+	URL    string
+	RawURL string
+
+	// Release is the newest tagged release, if there is one, and Ahead is
+	// the number of commits made since it.
+	Release Tag
+	Ahead   int
+
+	Activity Activity
+
+	// Error describes why repository information could not be determined.
+	Error string
 }
 
 type Revision struct {
@@ -24,18 +42,277 @@ type Revision struct {
 	Author string
 }
 
+// Tag is a tagged revision of a repository.
+type Tag struct {
+	Name     string
+	Revision string
+	Date     time.Time
+
+	// Version is the canonical form of Name if it is a semantic version.
+	Version string
+}
+
+// NewTag creates a Tag, parsing its name as a version.
+func NewTag(name, revision string, date time.Time) Tag {
+	t := Tag{Name: name, Revision: revision, Date: date}
+	if v, ok := ParseVersion(name); ok {
+		t.Version = v.String()
+	}
+	return t
+}
+
+// Version is a semantic version.
+type Version struct {
+	Major, Minor, Patch int
+	Prerelease          string
+}
+
+// ParseVersion parses a tag name such as v1.2.3, 1.2 or v2.0.0-rc1 as a
+// semantic version. Missing minor and patch numbers are taken to be zero.
+// Without a v prefix, at least the major and minor numbers are required, so
+// that tags such as 2014 or 1 aren't taken for releases.
+func ParseVersion(s string) (Version, bool) {
+	var v Version
+	prefixed := strings.HasPrefix(s, "v")
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		// Build metadata doesn't affect precedence.
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		v.Prerelease = s[i+1:]
+		if v.Prerelease == "" {
+			return v, false
+		}
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || (!prefixed && len(parts) < 2) {
+		return v, false
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		if p == "" || (len(p) > 1 && p[0] == '0') {
+			return v, false
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		*nums[i] = n
+	}
+	return v, true
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Less reports whether v has lower precedence than w. Prereleases are
+// ordered as in semantic versioning, except that numbers within an
+// identifier are compared numerically too, so that rc9 comes before rc10.
+func (v Version) Less(w Version) bool {
+	if v.Major != w.Major {
+		return v.Major < w.Major
+	}
+	if v.Minor != w.Minor {
+		return v.Minor < w.Minor
+	}
+	if v.Patch != w.Patch {
+		return v.Patch < w.Patch
+	}
+	if (v.Prerelease == "") != (w.Prerelease == "") {
+		return v.Prerelease != ""
+	}
+	return comparePrerelease(v.Prerelease, w.Prerelease) < 0
+}
+
+// comparePrerelease compares the dot separated identifiers of two
+// prerelease versions in turn, returning -1, 0 or 1 as a is lower than,
+// equal to or higher than b. A prerelease with more identifiers is higher
+// if the others are equal.
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := compareIdentifier(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(as), len(bs))
+}
+
+// compareIdentifier compares prerelease identifiers. Numeric identifiers
+// are lower than others. Otherwise identifiers are compared by runs of
+// digits, numerically, and of other characters, lexically.
+func compareIdentifier(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInt(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	ar, br := splitDigits(a), splitDigits(b)
+	for i := 0; i < len(ar) && i < len(br); i++ {
+		x, xErr := strconv.Atoi(ar[i])
+		y, yErr := strconv.Atoi(br[i])
+		if xErr == nil && yErr == nil {
+			if c := compareInt(x, y); c != 0 {
+				return c
+			}
+			continue
+		}
+		if c := strings.Compare(ar[i], br[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(ar), len(br))
+}
+
+// splitDigits splits s into alternating runs of digits and other
+// characters.
+func splitDigits(s string) []string {
+	var runs []string
+	start := 0
+	for i := 1; i <= len(s); i++ {
+		if i == len(s) || isDigit(s[i]) != isDigit(s[i-1]) {
+			runs = append(runs, s[start:i])
+			start = i
+		}
+	}
+	return runs
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// NewestRelease returns the tag with the highest version, preferring
+// releases over prereleases. It returns false if no tag is a version.
+func NewestRelease(tags []Tag) (Tag, bool) {
+	var (
+		newest  Tag
+		version Version
+		found   bool
+	)
+	for _, t := range tags {
+		v, ok := ParseVersion(t.Version)
+		if !ok {
+			continue
+		}
+		if !found || prefer(v, version) {
+			newest, version, found = t, v, true
+		}
+	}
+	return newest, found
+}
+
+// prefer reports whether v makes a better release than w.
+func prefer(v, w Version) bool {
+	if (v.Prerelease == "") != (w.Prerelease == "") {
+		return v.Prerelease == ""
+	}
+	return w.Less(v)
+}
+
+// RecentActivity is the period before a build in which commits count as
+// recent activity.
+const RecentActivity = 90 * 24 * time.Hour
+
+// Activity summarizes the commit history of a repository.
+type Activity struct {
+	Commits     int
+	Authors     int
+	First, Last time.Time
+
+	// Recent is the number of commits made in the RecentActivity before now.
+	Recent int
+}
+
+// NewActivity summarizes history as of now.
+func NewActivity(history []Revision, now time.Time) Activity {
+	var a Activity
+	authors := make(map[string]bool)
+	for _, r := range history {
+		a.Commits++
+		authors[r.Author] = true
+		if a.First.IsZero() || r.Date.Before(a.First) {
+			a.First = r.Date
+		}
+		if r.Date.After(a.Last) {
+			a.Last = r.Date
+		}
+		if now.Sub(r.Date) <= RecentActivity {
+			a.Recent++
+		}
+	}
+	a.Authors = len(authors)
+	return a
+}
+
 type Package struct {
 	Downloaded bool
 	ImportPath string
 	Imports    []string
 	Date       time.Time
+	GoVersion  string
 	Repository Repository
-	Build      Build
-	Test       Test
-	Gofmt      Gofmt
-	Vet        Vet
-	Errcheck   Errcheck
-	BuildInfo  BuildInfo
+
+	// Depth is the number of imports between the package and the package
+	// list in the crawl that built it. Listed reports whether the package
+	// is in the list itself; other packages in the repository of a listed
+	// package are at depth 0 too.
+	Depth  int
+	Listed bool
+
+	// Group and Pinned are the group and revision given for the package in
+	// the package list.
+	Group  string
+	Pinned string
+
+	// Dependencies are the repositories of the packages imported by the
+	// package or its tests, directly or indirectly, at the revisions it was
+	// built against.
+	Dependencies []Dependency
+
+	Download    Download
+	ImportCheck ImportCheck
+	Build       Build
+	Test        Test
+	Gofmt       Gofmt
+	Vet         Vet
+	Errcheck    Errcheck
+	BuildInfo   BuildInfo
+}
+
+// Dependency is a repository providing packages that a package depends on.
+type Dependency struct {
+	Repository Repository
+	Packages   []string
+}
+
+// Download records a failure to fetch a package.
+type Download struct {
+	Attempts  int
+	Error     string
+	Transient bool // whether the last failure looked temporary
 }
 
 type Build struct {
@@ -45,6 +322,7 @@ type Build struct {
 
 type Test struct {
 	Succeeded bool
+	Skipped   bool
 	Log       string
 }
 
@@ -78,50 +356,15 @@ func NewBuildInfo(pkg *build.Package) BuildInfo {
 	}
 }
 
-// GodocPackages retrieves a list of packages in the godoc.org index.
-func GodocPackages() ([]string, error) {
-	var results []string
-
-	resp, err := http.Get("http://api.godoc.org/packages")
-	if err != nil {
-		return results, err
-	}
-	defer resp.Body.Close()
-
-	dec := json.NewDecoder(resp.Body)
-	var w struct {
-		Results []struct {
-			Path string `json:"path"`
-		} `json:"results"`
-	}
-	err = dec.Decode(&w)
-	if err != nil {
-		return results, err
-	}
-
-	for _, result := range w.Results {
-		results = append(results, result.Path)
-	}
-
-	return results, nil
-}
-
-// FilePackages reads a list of packages from a file.
-func FilePackages(path string) ([]string, error) {
-	var lines []string
-	file, err := os.Open(path)
-	if err != nil {
-		return lines, fmt.Errorf("failed to open packages file: %d", err)
-	}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
-	}
-	return lines, scanner.Err()
-}
+// ErrNotFound is returned by Collection.Get when there is no record for a package.
+var ErrNotFound = errors.New("package not found")
 
 type Collection interface {
+	// Insert stores pkg, replacing any existing record with the same import path.
 	Insert(pkg Package) error
+
+	// Get retrieves the stored record for importPath.
+	Get(importPath string) (Package, error)
 }
 
 type MongoCollection struct {
@@ -140,6 +383,7 @@ func NewMongoCollection(host, db string) (*MongoCollection, error) {
 		return nil, fmt.Errorf("database ping failed: %s", err)
 	}
 
+	m.session = session
 	m.collection = session.DB(db).C("packages")
 	return &m, nil
 }
@@ -150,22 +394,47 @@ func (c *MongoCollection) Close() error {
 }
 
 func (c *MongoCollection) Insert(pkg Package) error {
-	return c.collection.Insert(pkg)
+	_, err := c.collection.Upsert(bson.M{"importpath": pkg.ImportPath}, pkg)
+	return err
+}
+
+func (c *MongoCollection) Get(importPath string) (Package, error) {
+	var pkg Package
+	err := c.collection.Find(bson.M{"importpath": importPath}).One(&pkg)
+	if err == mgo.ErrNotFound {
+		err = ErrNotFound
+	}
+	return pkg, err
 }
 
 type MemoryCollection struct {
+	mu       sync.RWMutex
 	Packages map[string]Package
 }
 
 func NewMemoryCollection() *MemoryCollection {
-	return &MemoryCollection{make(map[string]Package)}
+	return &MemoryCollection{Packages: make(map[string]Package)}
 }
 
 func (c *MemoryCollection) Insert(pkg Package) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Packages[pkg.ImportPath] = pkg
 	return nil
 }
 
+func (c *MemoryCollection) Get(importPath string) (Package, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	pkg, ok := c.Packages[importPath]
+	if !ok {
+		return pkg, ErrNotFound
+	}
+	return pkg, nil
+}
+
 func (c *MemoryCollection) Dump() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return json.MarshalIndent(c.Packages, "", "\t")
 }
@@ -0,0 +1,117 @@
+package gosrc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseVersion(t *testing.T) {
+	var tests = []struct {
+		tag     string
+		version string
+		ok      bool
+	}{
+		{"v1.2.3", "v1.2.3", true},
+		{"1.2.3", "v1.2.3", true},
+		{"v1.2", "v1.2.0", true},
+		{"v1", "v1.0.0", true},
+		{"1.2", "v1.2.0", true},
+		{"1", "", false},
+		{"2014", "", false},
+		{"v2.0.0-rc1", "v2.0.0-rc1", true},
+		{"v1.0.0+build5", "v1.0.0", true},
+		{"go1", "", false},
+		{"release", "", false},
+		{"v1.2.3.4", "", false},
+		{"v01.2", "", false},
+		{"v1.x", "", false},
+		{"v1.0-", "", false},
+	}
+	for _, test := range tests {
+		v, ok := ParseVersion(test.tag)
+		if ok != test.ok {
+			t.Errorf("ParseVersion(%q): got ok %v, want %v", test.tag, ok, test.ok)
+			continue
+		}
+		if ok && v.String() != test.version {
+			t.Errorf("ParseVersion(%q): got %s, want %s", test.tag, v, test.version)
+		}
+	}
+}
+
+func TestNewestRelease(t *testing.T) {
+	var tests = []struct {
+		tags   []string
+		newest string
+		ok     bool
+	}{
+		{[]string{"v1.0.0", "v1.10.0", "v1.9.0"}, "v1.10.0", true},
+		{[]string{"v1.0.0", "v2.0.0-beta", "weekly"}, "v1.0.0", true},
+		{[]string{"v2.0.0-alpha", "v2.0.0-beta"}, "v2.0.0-beta", true},
+		{[]string{"v1.0.0-rc9", "v1.0.0-rc10"}, "v1.0.0-rc10", true},
+		{[]string{"2014", "v0.1.0"}, "v0.1.0", true},
+		{[]string{"go1", "weekly"}, "", false},
+		{nil, "", false},
+	}
+	for _, test := range tests {
+		var tags []Tag
+		for _, name := range test.tags {
+			tags = append(tags, NewTag(name, "", time.Time{}))
+		}
+		newest, ok := NewestRelease(tags)
+		if ok != test.ok || newest.Name != test.newest {
+			t.Errorf("NewestRelease(%v): got %q, %v, want %q, %v", test.tags, newest.Name, ok, test.newest, test.ok)
+		}
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	// In increasing order of precedence.
+	versions := []string{
+		"v1.0.0-1",
+		"v1.0.0-2",
+		"v1.0.0-10",
+		"v1.0.0-alpha",
+		"v1.0.0-alpha.1",
+		"v1.0.0-alpha.2",
+		"v1.0.0-alpha.10",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta",
+		"v1.0.0-rc9",
+		"v1.0.0-rc10",
+		"v1.0.0",
+		"v1.0.1",
+		"v1.10.0",
+	}
+	for i := range versions {
+		for j := range versions {
+			v, _ := ParseVersion(versions[i])
+			w, _ := ParseVersion(versions[j])
+			if got, want := v.Less(w), i < j; got != want {
+				t.Errorf("%s.Less(%s) = %v, want %v", versions[i], versions[j], got, want)
+			}
+		}
+	}
+}
+
+func TestNewActivity(t *testing.T) {
+	now := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	history := []Revision{
+		{Id: "3", Author: "alice", Date: now.Add(-24 * time.Hour)},
+		{Id: "2", Author: "bob", Date: now.Add(-100 * 24 * time.Hour)},
+		{Id: "1", Author: "alice", Date: now.Add(-400 * 24 * time.Hour)},
+	}
+	want := Activity{
+		Commits: 3,
+		Authors: 2,
+		First:   now.Add(-400 * 24 * time.Hour),
+		Last:    now.Add(-24 * time.Hour),
+		Recent:  1,
+	}
+	if got := NewActivity(history, now); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := NewActivity(nil, now); got != (Activity{}) {
+		t.Errorf("empty history: got %+v", got)
+	}
+}
@@ -0,0 +1,119 @@
+package gosrc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ListEntry is a package in a package list, with the options given for it.
+//
+// Each line of a list holds an import path followed by any of these
+// attributes, separated by spaces:
+//
+//	group=NAME    file the package under a group, such as a team or project
+//	priority=N    crawl the package before those with a lower priority
+//	skip-tests    don't run the package's tests
+//	rev=REVISION  build the package at a pinned revision of its repository
+//
+// Blank lines are skipped, and # starts a comment that runs to the end of
+// the line.
+type ListEntry struct {
+	ImportPath string
+	Group      string `json:",omitempty"`
+	Priority   int    `json:",omitempty"`
+	SkipTests  bool   `json:",omitempty"`
+	Revision   string `json:",omitempty"`
+}
+
+// ParseList reads a package list from r.
+func ParseList(r io.Reader) ([]ListEntry, error) {
+	var entries []ListEntry
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		e := ListEntry{ImportPath: fields[0]}
+		for _, attr := range fields[1:] {
+			if err := e.set(attr); err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// set applies an attribute from a list line to e.
+func (e *ListEntry) set(attr string) error {
+	kv := strings.SplitN(attr, "=", 2)
+	key, value := kv[0], ""
+	if len(kv) == 2 {
+		value = kv[1]
+	}
+	switch key {
+	case "group":
+		e.Group = value
+	case "priority":
+		p, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("bad priority %q", value)
+		}
+		e.Priority = p
+	case "skip-tests":
+		if len(kv) == 1 {
+			e.SkipTests = true
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad skip-tests %q", value)
+		}
+		e.SkipTests = b
+		return nil
+	case "rev":
+		e.Revision = value
+	default:
+		return fmt.Errorf("unknown attribute %q", key)
+	}
+	if value == "" {
+		return fmt.Errorf("attribute %s has no value", key)
+	}
+	return nil
+}
+
+// ReadListFile reads a package list from a file.
+func ReadListFile(path string) ([]ListEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open packages file: %s", err)
+	}
+	defer file.Close()
+	entries, err := ParseList(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return entries, nil
+}
+
+// FilePackages reads the import paths in a package list file.
+func FilePackages(path string) ([]string, error) {
+	entries, err := ReadListFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var packages []string
+	for _, e := range entries {
+		packages = append(packages, e.ImportPath)
+	}
+	return packages, nil
+}
@@ -1,38 +1,97 @@
-// fetch retrieves a list of packages from the given source
+// list prints import paths to crawl, gathered from one or more sources.
+//
+// Arguments are import paths to list; those containing "..." are expanded
+// against the packages in the -gopath workspaces. The argument "godoc" is
+// kept as a synonym for -godoc.
 package main
 
 import (
 	"flag"
 	"fmt"
 	"github.com/kisielk/gosrc"
+	"go/build"
 	"log"
+	"net/http"
 	"os"
+	"time"
 )
 
+var (
+	godoc        = flag.Bool("godoc", false, "List the packages in the godoc.org index")
+	godocURL     = flag.String("godoc-url", gosrc.DefaultGodocURL, "Base URL of the godoc.org API server or mirror used by -godoc")
+	godocTimeout = flag.Duration("godoc-timeout", gosrc.DefaultGodocTimeout, "Time limit for the -godoc index request")
+	gopath       = flag.String("gopath", build.Default.GOPATH, "GOPATH to scan with -scan-gopath and to expand ... patterns against")
+	scan         = flag.Bool("scan-gopath", false, "List the packages in the -gopath workspaces")
+	tree         = flag.String("tree", "", "List the packages in the module tree rooted at this directory")
+	prefix       = flag.String("prefix", "", "Import path of the -tree directory, if it has no go.mod")
+	proxy        = flag.String("proxy", "", "List the modules in the index of the Go module proxy at this URL, such as https://index.golang.org")
+	since        = flag.String("since", "", "Oldest -proxy index entry to list, as an RFC 3339 time")
+	maxIndex     = flag.Int("max", 0, "Maximum number of module versions to read from the -proxy index, or 0 for all")
+	stdin        = flag.Bool("stdin", false, "List the import paths read from standard input, one per line")
+	filter       = gosrc.FilterFlags(flag.CommandLine)
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] [import paths]\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
 func main() {
 	log.SetFlags(0)
+	flag.Usage = usage
 	flag.Parse()
-	source := flag.Arg(0)
+	scope, err := filter()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	var (
-		packages []string
-		err      error
+		sources  []gosrc.Source
+		patterns []string
 	)
-
-	switch source {
-	case "godoc":
-		packages, err = gosrc.GodocPackages()
-	case "":
-		log.Fatalf("usage: %s [source]", os.Args[0])
-	default:
-		log.Fatalln("unknown source:", source)
+	for _, arg := range flag.Args() {
+		if arg == "godoc" {
+			*godoc = true
+		} else {
+			patterns = append(patterns, arg)
+		}
+	}
+	if *godoc {
+		sources = append(sources, &gosrc.GodocClient{BaseURL: *godocURL, Timeout: *godocTimeout})
+	}
+	if *scan {
+		sources = append(sources, gosrc.GOPATHSource{GOPATH: *gopath})
+	}
+	if *tree != "" {
+		sources = append(sources, gosrc.TreeSource{Dir: *tree, Prefix: *prefix})
+	}
+	if *proxy != "" {
+		s := gosrc.ProxySource{URL: *proxy, Max: *maxIndex, Client: &http.Client{Timeout: time.Minute}}
+		if *since != "" {
+			t, err := time.Parse(time.RFC3339, *since)
+			if err != nil {
+				log.Fatalln("bad -since:", err)
+			}
+			s.Since = t
+		}
+		sources = append(sources, s)
+	}
+	if *stdin {
+		sources = append(sources, gosrc.ReaderSource{R: os.Stdin})
+	}
+	if len(patterns) > 0 {
+		sources = append(sources, gosrc.PatternSource{Patterns: patterns, Source: gosrc.GOPATHSource{GOPATH: *gopath}})
+	}
+	if len(sources) == 0 {
+		usage()
 	}
 
+	packages, err := gosrc.Combine(sources...).Packages()
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	for _, pkg := range packages {
+	for _, pkg := range scope.Apply(packages) {
 		fmt.Println(pkg)
 	}
 }
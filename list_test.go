@@ -0,0 +1,65 @@
+package gosrc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	list := `# Packages to crawl.
+github.com/kisielk/gosrc
+
+github.com/kisielk/errcheck group=tools priority=10   # check this first
+  launchpad.net/twik skip-tests rev=4
+example.com/pinned rev=abc123 skip-tests=false group=misc
+`
+	entries, err := ParseList(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ListEntry{
+		{ImportPath: "github.com/kisielk/gosrc"},
+		{ImportPath: "github.com/kisielk/errcheck", Group: "tools", Priority: 10},
+		{ImportPath: "launchpad.net/twik", SkipTests: true, Revision: "4"},
+		{ImportPath: "example.com/pinned", Group: "misc", Revision: "abc123"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %+v, want %+v", entries, want)
+	}
+
+	for _, bad := range []string{
+		"example.com/a priority=high",
+		"example.com/a color=red",
+		"example.com/a rev=",
+		"example.com/a group",
+		"example.com/a skip-tests=maybe",
+	} {
+		if _, err := ParseList(strings.NewReader("example.com/ok\n" + bad)); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("%q: got error %v, want one for line 2", bad, err)
+		}
+	}
+}
+
+func TestFilePackages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosrc-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "packages")
+	if err := ioutil.WriteFile(path, []byte("# comment\nexample.com/a group=x\n\nexample.com/b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err := FilePackages(path)
+	if want := []string{"example.com/a", "example.com/b"}; err != nil || !reflect.DeepEqual(pkgs, want) {
+		t.Errorf("got %q, %v, want %q", pkgs, err, want)
+	}
+	_, err = FilePackages(filepath.Join(dir, "missing"))
+	if err == nil || strings.Contains(err.Error(), "%!d") {
+		t.Errorf("missing file: got error %v", err)
+	}
+}
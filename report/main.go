@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/kisielk/gosrc"
 	"html/template"
 	"labix.org/v2/mgo"
@@ -11,6 +12,7 @@ import (
 	"net/url"
 	"path"
 	"path/filepath"
+	"time"
 )
 
 var (
@@ -41,22 +43,28 @@ const indexTemplate = `
 <table>
 <tr>
 <th>Import Path</th>
+<th>Depth</th>
 <th>Build</th>
 <th>Test</th>
 <th>Vet</th>
 <th>Errcheck</th>
 <th>Revision</th>
+<th>Import Path Tags</th>
+<th>Release</th>
 <th>Repository</th>
 </tr>
 {{range .Packages}}
 <tr>
 <td><a href="/{{.ImportPath}}">{{.ImportPath}}</a></td>
+<td>{{.Depth}}</td>
 <td>{{if .Build.Succeeded}}<span class="check">✔</span>{{else}}<span class="cross">✘</span>{{end}}</td>
-<td>{{if .Test.Succeeded}}<span class="check">✔</span>{{else}}<span class="cross">✘</span>{{end}}</td>
+<td>{{if .Test.Skipped}}skipped{{else}}{{if .Test.Succeeded}}<span class="check">✔</span>{{else}}<span class="cross">✘</span>{{end}}{{end}}</td>
 <td>{{.Vet.Errors}}</td>
 <td>{{.Errcheck.Errors}}</td>
 <td>{{.Repository.Revision.Id | limit 10}}</td>
-<td><a href="/-/repo?r={{.Repository.URL}}">{{.Repository.URL}}</a></td>
+<td>{{if .ImportCheck.Checked}}{{if .ImportCheck.OK}}<span class="check">✔</span>{{else}}<span class="cross">✘</span>{{end}}{{end}}</td>
+<td>{{with .Repository.Release.Name}}{{.}}{{else}}none{{end}}</td>
+<td><a href="/-/repo/?r={{.Repository.URL | queryEscape}}">{{.Repository.URL}}</a></td>
 </tr>
 {{end}}
 </table>
@@ -73,15 +81,66 @@ const packageTemplate = `
 <body>
 <h1>{{.ImportPath}}</h1>
 <a href="/-/files/{{.ImportPath}}">Files</a>
+<p>{{if .Listed}}In the package list{{else}}{{if .Depth}}Reached through {{.Depth}} imports from the package list{{else}}In the repository of a package in the package list{{end}}{{end}}</p>
+{{with .Group}}
+<p>Group: {{.}}</p>
+{{end}}
+{{with .Pinned}}
+<p>Pinned to revision {{.}} by the package list</p>
+{{end}}
+{{with .Download.Error}}
+<h2>Download Failed</h2>
+<pre>
+{{.}}
+</pre>
+{{end}}
+{{with .ImportCheck}}{{if .Checked}}
+<h2>Import Path</h2>
+<dl>
+<dt>go-import</dt>
+<dd>{{with .Root}}{{.}} {{$.ImportCheck.VCS}} {{$.ImportCheck.Repo}}{{else}}none{{end}}</dd>
+<dt>go-source</dt>
+<dd>{{with .Source}}{{.}}{{else}}none{{end}}</dd>
+</dl>
+{{with .Problems}}
+<p>The import path is misconfigured:</p>
+<ul>
+{{range .}}
+<li>{{.}}</li>
+{{end}}
+</ul>
+{{end}}
+{{end}}{{end}}
 <h2>Revision</h2>
-{{with .Repository.Revision}}
+{{with .Repository.Error}}
+<p>Repository information is incomplete: {{.}}</p>
+{{end}}
+{{with .Repository}}
 <dl>
+{{with .URL}}
+<dt>URL</dt>
+<dd><a href="/-/repo/?r={{. | queryEscape}}">{{.}}</a> ({{$.Repository.RawURL | redactURL}})</dd>
+{{end}}
 <dt>Id</dt>
-<dd>{{.Id}}</dd>
+<dd>{{.Revision.Id}}</dd>
 <dt>Author</dt>
-<dd>{{.Author}}</dd>
+<dd>{{.Revision.Author}}</dd>
 <dt>Date</dt>
-<dd>{{.Date}}</dd>
+<dd>{{.Revision.Date}}</dd>
+{{with .Branch}}
+<dt>Branch</dt>
+<dd>{{.}}</dd>
+{{end}}
+{{with .Tags}}
+<dt>Tags</dt>
+<dd>{{range .}}{{.Name}} {{end}}</dd>
+{{end}}
+<dt>Release</dt>
+{{with .Release.Name}}
+<dd>{{.}} ({{$.Repository.Release.Date}}), {{$.Repository.Ahead}} commits ahead</dd>
+{{else}}
+<dd>No tagged release</dd>
+{{end}}
 </dl>
 {{end}}
 <h2>Build Log</h2>
@@ -89,9 +148,13 @@ const packageTemplate = `
 {{.Build.Log}}
 </pre>
 <h2>Test Log</h2>
+{{if .Test.Skipped}}
+<p>Tests were skipped.</p>
+{{else}}
 <pre>
 {{.Test.Log}}
 </pre>
+{{end}}
 <h2>Vet Log</h2>
 <pre>
 {{.Vet.Log}}
@@ -100,6 +163,24 @@ const packageTemplate = `
 <pre>
 {{.Errcheck.Log}}
 </pre>
+<h2>Dependencies</h2>
+<a href="/-/lock/{{.ImportPath}}">Download lockfile</a>
+<table>
+<tr>
+<th>Repository</th>
+<th>Type</th>
+<th>Revision</th>
+<th>Packages</th>
+</tr>
+{{range .Dependencies}}
+<tr>
+<td>{{.Repository.Root}}</td>
+<td>{{.Repository.Type}}</td>
+<td>{{.Repository.Revision.Id}}</td>
+<td>{{range .Packages}}<a href="/{{.}}">{{.}}</a> {{end}}</td>
+</tr>
+{{end}}
+</table>
 <h2>Imports</h2>
 <ul>
 {{range .BuildInfo.Imports}}
@@ -117,6 +198,21 @@ const repoTemplate = `
 <title>Repo {{.URL}}</title>
 </head>
 <body>
+{{with .Activity}}
+<h1>Activity</h1>
+<dl>
+<dt>Commits</dt>
+<dd>{{.Commits}}</dd>
+<dt>Authors</dt>
+<dd>{{.Authors}}</dd>
+<dt>First commit</dt>
+<dd>{{.First}}</dd>
+<dt>Last commit</dt>
+<dd>{{.Last}}</dd>
+<dt>Commits in the last 90 days</dt>
+<dd>{{.Recent}}</dd>
+</dl>
+{{end}}
 <h1>Packages</h1>
 <ul>
 {{range .Packages}}
@@ -169,6 +265,7 @@ func parseTemplate(name, t string) *template.Template {
 
 var funcMap = template.FuncMap{
 	"queryEscape": url.QueryEscape,
+	"redactURL":   gosrc.RedactURL,
 	"limit": func(n int, s string) string {
 		runes := []rune(s)
 		if n > len(runes) {
@@ -206,14 +303,19 @@ func getPackage(w http.ResponseWriter, req *http.Request) {
 
 func getRepo(w http.ResponseWriter, req *http.Request) {
 	c := session.DB(*database).C("packages")
-	repo := req.FormValue("r")
+	repo := gosrc.NormalizeURL(req.FormValue("r"))
 	var packages []gosrc.Package
 	log.Println(repo)
 	err := c.Find(bson.M{"repository.url": repo}).Iter().All(&packages)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := map[string]interface{}{"URL": repo, "Packages": packages}
+	if len(packages) > 0 {
+		data["Activity"] = packages[0].Repository.Activity
 	}
-	err = templates["repo"].Execute(w, map[string]interface{}{"URL": repo, "Packages": packages})
+	err = templates["repo"].Execute(w, data)
 	if err != nil {
 		log.Print(err)
 	}
@@ -243,6 +345,31 @@ func getFile(w http.ResponseWriter, req *http.Request) {
 	http.ServeFile(w, req, path)
 }
 
+// getLockfile serves the dependency revisions a package was built against,
+// one repository per line.
+func getLockfile(w http.ResponseWriter, req *http.Request) {
+	pkg, err := findPackage(req.URL.Path[len(lockPath):])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(pkg.ImportPath)+".lock"))
+	fmt.Fprintf(w, "# %s built %s with %s\n", pkg.ImportPath, pkg.Date.Format(time.RFC3339), pkg.GoVersion)
+	for _, dep := range pkg.Dependencies {
+		repo := dep.Repository
+		fmt.Fprintln(w, repo.Root, orDash(repo.Type), orDash(repo.Revision.Id), orDash(gosrc.RedactURL(repo.RawURL)))
+	}
+}
+
+// orDash returns s, or "-" if s is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
 func findPackage(path string) (gosrc.Package, error) {
 	c := session.DB(*database).C("packages")
 	var pkg gosrc.Package
@@ -255,6 +382,7 @@ const (
 	repoPath  = "/-/repo/"
 	filesPath = "/-/files/"
 	filePath  = "/-/file/"
+	lockPath  = "/-/lock/"
 )
 
 func main() {
@@ -272,6 +400,7 @@ func main() {
 	http.HandleFunc(repoPath, getRepo)
 	http.HandleFunc(filesPath, getFiles)
 	http.HandleFunc(filePath, getFile)
+	http.HandleFunc(lockPath, getLockfile)
 	http.HandleFunc("/", getPackage)
 	err = http.ListenAndServe(*httpAddr, nil)
 	if err != nil {
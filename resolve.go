@@ -0,0 +1,249 @@
+package gosrc
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RepoRoot describes the repository containing an import path.
+type RepoRoot struct {
+	VCS  string // git, hg, bzr, svn or fossil
+	Repo string // URL of the repository
+	Root string // import path of the repository root
+}
+
+// Resolver maps import paths to repositories without downloading them, in
+// the same way as `go get`: first by the rules for well known hosts, then by
+// looking for <meta name="go-import"> tags on the page at the import path.
+// The zero Resolver is ready to use.
+type Resolver struct {
+	// Client is used for discovery requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Insecure allows discovery over plain HTTP when HTTPS fails.
+	Insecure bool
+
+	mu     sync.Mutex
+	roots  map[string]RepoRoot    // by Root
+	checks map[string]ImportCheck // by Root
+}
+
+// ErrStandard is returned when resolving a standard library package.
+var ErrStandard = errors.New("standard library package")
+
+// Resolve returns the repository containing importPath.
+func (r *Resolver) Resolve(importPath string) (RepoRoot, error) {
+	if root, ok := r.cached(importPath); ok {
+		return root, nil
+	}
+	if !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".") {
+		return RepoRoot{}, ErrStandard
+	}
+	root, ok, err := knownRoot(importPath)
+	if !ok && err == nil {
+		root, err = r.discover(importPath)
+	}
+	if err != nil {
+		return RepoRoot{}, err
+	}
+	r.mu.Lock()
+	if r.roots == nil {
+		r.roots = make(map[string]RepoRoot)
+	}
+	r.roots[root.Root] = root
+	r.mu.Unlock()
+	return root, nil
+}
+
+// cached returns a previously resolved repository containing importPath.
+func (r *Resolver) cached(importPath string) (RepoRoot, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range parentPaths(importPath) {
+		if root, ok := r.roots[p]; ok {
+			return root, true
+		}
+	}
+	return RepoRoot{}, false
+}
+
+// parentPaths returns importPath and the import paths that contain it,
+// longest first.
+func parentPaths(importPath string) []string {
+	var paths []string
+	for p := importPath; p != ""; {
+		paths = append(paths, p)
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	return paths
+}
+
+// knownHost describes how to find the repository root of import paths on a
+// host without a network request.
+type knownHost struct {
+	prefix string
+	re     *regexp.Regexp // matches import paths, the first group is the root
+	vcs    string
+	scheme string
+}
+
+var knownHosts = []knownHost{
+	{"github.com/", regexp.MustCompile(`^(github\.com/[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+)(/[A-Za-z0-9_.\-]+)*$`), "git", "https"},
+	{"hub.jazz.net/git/", regexp.MustCompile(`^(hub\.jazz\.net/git/[a-z0-9]+/[A-Za-z0-9_.\-]+)(/[A-Za-z0-9_.\-]+)*$`), "git", "https"},
+	{"launchpad.net/", regexp.MustCompile(`^(launchpad\.net/(?:[A-Za-z0-9_.\-]+(?:/[A-Za-z0-9_.\-]+)?|~[A-Za-z0-9_.\-]+/(?:[A-Za-z0-9_.\-]+|\+junk)/[A-Za-z0-9_.\-]+))(/[A-Za-z0-9_.\-]+)*$`), "bzr", "https"},
+}
+
+// vcsSuffix matches import paths that name their VCS explicitly, like
+// example.org/repo.git/sub.
+var vcsSuffix = regexp.MustCompile(`^((?:[a-z0-9\-]+\.)+[a-z0-9\-]+(?::[0-9]+)?/[A-Za-z0-9_.\-/]*?)\.(bzr|git|hg|svn)(/[A-Za-z0-9_.\-]+)*$`)
+
+// knownRoot resolves importPath by the known host rules. It reports false
+// if no rule applies.
+func knownRoot(importPath string) (RepoRoot, bool, error) {
+	for _, h := range knownHosts {
+		if !strings.HasPrefix(importPath, h.prefix) {
+			continue
+		}
+		m := h.re.FindStringSubmatch(importPath)
+		if m == nil {
+			return RepoRoot{}, false, fmt.Errorf("invalid import path %q", importPath)
+		}
+		return RepoRoot{VCS: h.vcs, Repo: h.scheme + "://" + m[1], Root: m[1]}, true, nil
+	}
+	if m := vcsSuffix.FindStringSubmatch(importPath); m != nil {
+		root := m[1] + "." + m[2]
+		return RepoRoot{VCS: m[2], Repo: "https://" + m[1], Root: root}, true, nil
+	}
+	return RepoRoot{}, false, nil
+}
+
+func (r *Resolver) client() *http.Client {
+	if r.Client == nil {
+		return http.DefaultClient
+	}
+	return r.Client
+}
+
+// discover resolves importPath from the go-import meta tags served at it.
+func (r *Resolver) discover(importPath string) (RepoRoot, error) {
+	metas, err := r.fetchMeta(importPath)
+	if err != nil {
+		return RepoRoot{}, err
+	}
+	return matchGoImport(goImports(metas), importPath)
+}
+
+// fetchMeta returns the meta tags of the page served for importPath.
+func (r *Resolver) fetchMeta(importPath string) ([]meta, error) {
+	resp, err := r.client().Get("https://" + importPath + "?go-get=1")
+	if err != nil && r.Insecure {
+		resp, err = r.client().Get("http://" + importPath + "?go-get=1")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Error pages may still carry the meta tags, as with `go get`.
+	metas, err := parseMeta(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", importPath, err)
+	}
+	return metas, nil
+}
+
+// metaImport is the content of a <meta name="go-import"> tag.
+type metaImport struct {
+	Prefix, VCS, RepoRoot string
+}
+
+// goImports returns the well formed go-import tags in metas.
+func goImports(metas []meta) []metaImport {
+	var imports []metaImport
+	for _, m := range metas {
+		if m.name != "go-import" {
+			continue
+		}
+		if f := strings.Fields(m.content); len(f) == 3 {
+			imports = append(imports, metaImport{f[0], f[1], f[2]})
+		}
+	}
+	return imports
+}
+
+type meta struct {
+	name, content string
+}
+
+// parseMeta returns the meta tags in the head of an HTML document.
+func parseMeta(r io.Reader) ([]meta, error) {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "ascii", "utf-8", "us-ascii":
+			return input, nil
+		}
+		return nil, fmt.Errorf("can't decode charset %q", charset)
+	}
+	var metas []meta
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(metas) > 0 {
+				err = nil
+			}
+			return metas, err
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			return metas, nil
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return metas, nil
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
+			continue
+		}
+		var m meta
+		for _, a := range e.Attr {
+			switch strings.ToLower(a.Name.Local) {
+			case "name":
+				m.name = a.Value
+			case "content":
+				m.content = a.Value
+			}
+		}
+		metas = append(metas, m)
+	}
+}
+
+// matchGoImport returns the repository of the go-import tag whose prefix
+// contains importPath.
+func matchGoImport(imports []metaImport, importPath string) (RepoRoot, error) {
+	var match *metaImport
+	for i, im := range imports {
+		if importPath != im.Prefix && !strings.HasPrefix(importPath, im.Prefix+"/") {
+			continue
+		}
+		if match != nil {
+			return RepoRoot{}, fmt.Errorf("multiple go-import tags match %s", importPath)
+		}
+		match = &imports[i]
+	}
+	if match == nil {
+		return RepoRoot{}, fmt.Errorf("no go-import tag for %s", importPath)
+	}
+	return RepoRoot{VCS: match.VCS, Repo: match.RepoRoot, Root: match.Prefix}, nil
+}
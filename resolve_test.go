@@ -0,0 +1,125 @@
+package gosrc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKnownRoot(t *testing.T) {
+	var tests = []struct {
+		path string
+		root RepoRoot
+		ok   bool
+	}{
+		{"github.com/kisielk/gosrc/build", RepoRoot{"git", "https://github.com/kisielk/gosrc", "github.com/kisielk/gosrc"}, true},
+		{"github.com/kisielk/gosrc", RepoRoot{"git", "https://github.com/kisielk/gosrc", "github.com/kisielk/gosrc"}, true},
+		{"launchpad.net/twik", RepoRoot{"bzr", "https://launchpad.net/twik", "launchpad.net/twik"}, true},
+		{"launchpad.net/~niemeyer/twik/trunk/sub", RepoRoot{"bzr", "https://launchpad.net/~niemeyer/twik/trunk", "launchpad.net/~niemeyer/twik/trunk"}, true},
+		{"example.org/repo.git/sub", RepoRoot{"git", "https://example.org/repo", "example.org/repo.git"}, true},
+		{"example.org/user/repo.hg", RepoRoot{"hg", "https://example.org/user/repo", "example.org/user/repo.hg"}, true},
+		{"example.org/vanity", RepoRoot{}, false},
+	}
+	for _, test := range tests {
+		root, ok, err := knownRoot(test.path)
+		if err != nil {
+			t.Errorf("%s: %s", test.path, err)
+			continue
+		}
+		if root != test.root || ok != test.ok {
+			t.Errorf("%s: got %+v, %v, want %+v, %v", test.path, root, ok, test.root, test.ok)
+		}
+	}
+	if _, _, err := knownRoot("github.com/kisielk"); err == nil {
+		t.Error("expected error for incomplete github path")
+	}
+}
+
+func TestGoImports(t *testing.T) {
+	page := `<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="go-import" content="example.org/a git https://git.example.org/a">
+<meta name="go-source" content="example.org/a _ _ _">
+<META NAME="go-import" CONTENT="example.org/b hg https://hg.example.org/b">
+<meta name="go-import" content="malformed">
+</head>
+<body>
+<meta name="go-import" content="example.org/c git https://git.example.org/c">
+</body>
+</html>`
+	metas, err := parseMeta(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports := goImports(metas)
+	want := []metaImport{
+		{"example.org/a", "git", "https://git.example.org/a"},
+		{"example.org/b", "hg", "https://hg.example.org/b"},
+	}
+	if len(imports) != len(want) {
+		t.Fatalf("got %+v, want %+v", imports, want)
+	}
+	for i := range want {
+		if imports[i] != want[i] {
+			t.Errorf("got %+v, want %+v", imports[i], want[i])
+		}
+	}
+}
+
+func TestResolverDiscovery(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if req.FormValue("go-get") != "1" {
+			http.Error(w, "missing go-get", http.StatusBadRequest)
+			return
+		}
+		host := req.Host
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/vanity"):
+			fmt.Fprintf(w, `<html><head><meta name="go-import" content="%s/vanity git https://github.com/kisielk/vanity"></head></html>`, host)
+		case strings.HasPrefix(req.URL.Path, "/ambiguous"):
+			fmt.Fprintf(w, `<html><head>
+<meta name="go-import" content="%[1]s/ambiguous git https://a.example.org">
+<meta name="go-import" content="%[1]s/ambiguous/sub git https://b.example.org">
+</head></html>`, host)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	r := &Resolver{Insecure: true}
+	root, err := r.Resolve(host + "/vanity/sub/pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RepoRoot{"git", "https://github.com/kisielk/vanity", host + "/vanity"}
+	if root != want {
+		t.Errorf("got %+v, want %+v", root, want)
+	}
+
+	// Other packages in the repository come from the cache.
+	n := requests
+	if root, err := r.Resolve(host + "/vanity/other"); err != nil || root != want {
+		t.Errorf("got %+v, %v, want %+v", root, err, want)
+	}
+	if requests != n {
+		t.Errorf("made %d requests for a cached repository", requests-n)
+	}
+
+	if _, err := r.Resolve(host + "/ambiguous/sub"); err == nil {
+		t.Error("expected error for ambiguous go-import tags")
+	}
+	if _, err := r.Resolve(host + "/missing"); err == nil {
+		t.Error("expected error for page without go-import tags")
+	}
+	if _, err := r.Resolve("net/http"); err != ErrStandard {
+		t.Errorf("got %v, want %v", err, ErrStandard)
+	}
+}
@@ -0,0 +1,331 @@
+package gosrc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Source produces a list of import paths to crawl.
+type Source interface {
+	Packages() ([]string, error)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func() ([]string, error)
+
+func (f SourceFunc) Packages() ([]string, error) {
+	return f()
+}
+
+// Combine returns a Source listing the packages of each source in turn,
+// without duplicates.
+func Combine(sources ...Source) Source {
+	return SourceFunc(func() ([]string, error) {
+		var packages []string
+		seen := make(map[string]bool)
+		for _, s := range sources {
+			pkgs, err := s.Packages()
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range pkgs {
+				if !seen[p] {
+					seen[p] = true
+					packages = append(packages, p)
+				}
+			}
+		}
+		return packages, nil
+	})
+}
+
+// ReaderSource lists the import paths in a package list read from R. See
+// ListEntry for the format.
+type ReaderSource struct {
+	R io.Reader
+}
+
+func (s ReaderSource) Packages() ([]string, error) {
+	entries, err := ParseList(s.R)
+	if err != nil {
+		return nil, err
+	}
+	var packages []string
+	for _, e := range entries {
+		packages = append(packages, e.ImportPath)
+	}
+	return packages, nil
+}
+
+// GOPATHSource lists the packages in the src directories of a GOPATH, which
+// may hold several workspaces separated by filepath.ListSeparator.
+type GOPATHSource struct {
+	GOPATH string
+}
+
+func (s GOPATHSource) Packages() ([]string, error) {
+	var packages []string
+	for _, dir := range filepath.SplitList(s.GOPATH) {
+		if dir == "" {
+			continue
+		}
+		pkgs, err := scanTree(filepath.Join(dir, "src"), "", false)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, pkgs...)
+	}
+	return packages, nil
+}
+
+// TreeSource lists the packages in a module tree rooted at Dir. Prefix is
+// the import path of Dir; if it is empty, the module path in Dir/go.mod is
+// used. Nested modules and vendor directories aren't part of the tree.
+type TreeSource struct {
+	Dir    string
+	Prefix string
+}
+
+func (s TreeSource) Packages() ([]string, error) {
+	prefix := s.Prefix
+	if prefix == "" {
+		var err error
+		if prefix, err = ModulePath(filepath.Join(s.Dir, "go.mod")); err != nil {
+			return nil, err
+		}
+	}
+	return scanTree(s.Dir, prefix, true)
+}
+
+var moduleLine = regexp.MustCompile(`(?m)^\s*module\s+("[^"]+"|\S+)`)
+
+// ModulePath returns the module path declared in a go.mod file.
+func ModulePath(gomod string) (string, error) {
+	data, err := ioutil.ReadFile(gomod)
+	if err != nil {
+		return "", err
+	}
+	m := moduleLine.FindSubmatch(data)
+	if m == nil {
+		return "", fmt.Errorf("%s: no module path", gomod)
+	}
+	if p, err := strconv.Unquote(string(m[1])); err == nil {
+		return p, nil
+	}
+	return string(m[1]), nil
+}
+
+// scanTree returns the import paths of the directories under root that hold
+// Go files, where root has import path prefix. Directories ignored by the go
+// tool are skipped, and so are vendor directories and nested modules if
+// module is set.
+func scanTree(root, prefix string, module bool) ([]string, error) {
+	var packages []string
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			// Unreadable directories are skipped, as by the go tool.
+			return nil
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if p != root {
+			name := fi.Name()
+			if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" {
+				return filepath.SkipDir
+			}
+			if module {
+				if name == "vendor" {
+					return filepath.SkipDir
+				}
+				if _, err := os.Stat(filepath.Join(p, "go.mod")); err == nil {
+					return filepath.SkipDir
+				}
+			}
+		}
+		if !hasGoFiles(p) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); rel == "." {
+			rel = ""
+		}
+		if pkg := path.Join(prefix, rel); pkg != "" {
+			packages = append(packages, pkg)
+		}
+		return nil
+	})
+	return packages, err
+}
+
+// hasGoFiles reports whether dir holds Go source files other than tests.
+func hasGoFiles(dir string) bool {
+	names, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return false
+	}
+	for _, n := range names {
+		if !strings.HasSuffix(n, "_test.go") {
+			return true
+		}
+	}
+	return false
+}
+
+// PatternSource lists the packages matching Patterns. Patterns containing
+// "..." are expanded against the packages of Source; others are listed as
+// they are.
+type PatternSource struct {
+	Patterns []string
+	Source   Source
+}
+
+func (s PatternSource) Packages() ([]string, error) {
+	var (
+		packages []string
+		all      []string
+		loaded   bool
+	)
+	for _, pattern := range s.Patterns {
+		if !strings.Contains(pattern, "...") {
+			packages = append(packages, pattern)
+			continue
+		}
+		if !loaded {
+			var err error
+			if all, err = s.Source.Packages(); err != nil {
+				return nil, err
+			}
+			loaded = true
+		}
+		match := MatchPattern(pattern)
+		for _, p := range all {
+			if match(p) {
+				packages = append(packages, p)
+			}
+		}
+	}
+	return packages, nil
+}
+
+// MatchPattern returns a function reporting whether an import path matches
+// pattern, in which "..." matches any string, as with the go tool, and *
+// matches within a path element. A pattern ending in "/..." also matches
+// the path before it.
+func MatchPattern(pattern string) func(string) bool {
+	return compileGlob(pattern).MatchString
+}
+
+// ProxySource lists the module paths in the index of a Go module proxy,
+// such as https://index.golang.org.
+type ProxySource struct {
+	// URL is the base URL of the index; /index is appended.
+	URL string
+
+	// Since is the time of the oldest entry to list.
+	Since time.Time
+
+	// Max is the maximum number of module versions to read. Zero means
+	// read until the end of the index.
+	Max int
+
+	// Client is used for index requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// proxyPageSize is the number of index entries requested at once.
+var proxyPageSize = 2000
+
+// proxyEntry is a line of the module index.
+type proxyEntry struct {
+	Path      string
+	Version   string
+	Timestamp time.Time
+}
+
+func (s ProxySource) Packages() ([]string, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var packages []string
+	seen := make(map[string]bool)
+	versions := make(map[proxyEntry]bool)
+	since := s.Since
+	for {
+		entries, err := s.page(client, since, proxyPageSize)
+		if err != nil {
+			return nil, err
+		}
+		// Pages start at since inclusive, so they overlap at the ends.
+		advanced := false
+		for _, e := range entries {
+			key := proxyEntry{Path: e.Path, Version: e.Version}
+			if versions[key] {
+				continue
+			}
+			versions[key] = true
+			advanced = true
+			if !seen[e.Path] {
+				seen[e.Path] = true
+				packages = append(packages, e.Path)
+			}
+			if s.Max > 0 && len(versions) == s.Max {
+				return packages, nil
+			}
+		}
+		if len(entries) < proxyPageSize {
+			return packages, nil
+		}
+		if !advanced {
+			return nil, fmt.Errorf("module index at %s is not advancing past %s", s.URL, since.Format(time.RFC3339Nano))
+		}
+		since = entries[len(entries)-1].Timestamp
+	}
+}
+
+// page reads up to limit index entries from since onwards.
+func (s ProxySource) page(client *http.Client, since time.Time, limit int) ([]proxyEntry, error) {
+	q := url.Values{}
+	if !since.IsZero() {
+		q.Set("since", since.UTC().Format(time.RFC3339Nano))
+	}
+	q.Set("limit", strconv.Itoa(limit))
+	u := strings.TrimSuffix(s.URL, "/") + "/index?" + q.Encode()
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	var entries []proxyEntry
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e proxyEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %s", u, err)
+		}
+		entries = append(entries, e)
+	}
+}
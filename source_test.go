@@ -0,0 +1,164 @@
+package gosrc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTree creates files, given by slash separated paths relative to dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkPackages(t *testing.T, name string, s Source, want []string) {
+	got, err := s.Packages()
+	if err != nil {
+		t.Errorf("%s: %s", name, err)
+		return
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: got %q, want %q", name, got, want)
+	}
+}
+
+func TestTreeSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosrc-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		"gopath/src/example.com/a/a.go":               "package a",
+		"gopath/src/example.com/a/b/b.go":             "package b",
+		"gopath/src/example.com/a/testonly/x_test.go": "package testonly",
+		"gopath/src/example.com/a/testdata/t.go":      "package t",
+		"gopath/src/example.com/a/_old/o.go":          "package o",
+		"gopath/src/example.com/a/.hidden/h.go":       "package h",
+		"gopath2/src/example.org/c/c.go":              "package c",
+
+		"mod/go.mod":                    "// The module.\nmodule \"example.net/mod\"\n\ngo 1.12\n",
+		"mod/m.go":                      "package mod",
+		"mod/sub/s.go":                  "package sub",
+		"mod/vendor/example.com/v/v.go": "package v",
+		"mod/nested/go.mod":             "module example.net/nested\n",
+		"mod/nested/n.go":               "package nested",
+	})
+
+	gopath := filepath.Join(dir, "gopath") + string(filepath.ListSeparator) + filepath.Join(dir, "gopath2")
+	checkPackages(t, "GOPATHSource", GOPATHSource{gopath}, []string{"example.com/a", "example.com/a/b", "example.org/c"})
+	checkPackages(t, "TreeSource", TreeSource{Dir: filepath.Join(dir, "mod")}, []string{"example.net/mod", "example.net/mod/sub"})
+	checkPackages(t, "TreeSource with prefix", TreeSource{Dir: filepath.Join(dir, "mod", "nested"), Prefix: "example.net/other"}, []string{"example.net/other"})
+	if _, err := (TreeSource{Dir: filepath.Join(dir, "gopath")}).Packages(); err == nil {
+		t.Error("TreeSource without go.mod or prefix: expected error")
+	}
+
+	patterns := PatternSource{
+		Patterns: []string{"example.com/a/...", "example.org/...", "golang.org/x/net"},
+		Source:   GOPATHSource{gopath},
+	}
+	checkPackages(t, "PatternSource", patterns, []string{"example.com/a", "example.com/a/b", "example.org/c", "golang.org/x/net"})
+}
+
+func TestMatchPattern(t *testing.T) {
+	var tests = []struct {
+		pattern, path string
+		match         bool
+	}{
+		{"example.com/a/...", "example.com/a", true},
+		{"example.com/a/...", "example.com/a/b/c", true},
+		{"example.com/a/...", "example.com/ab", false},
+		{"example.com/a...", "example.com/ab", true},
+		{"example.com/.../b", "example.com/x/y/b", true},
+		{"example.com/.../b", "example.com/x/y/c", false},
+		{"example.com/a", "example.com/a/b", false},
+	}
+	for _, test := range tests {
+		if got := MatchPattern(test.pattern)(test.path); got != test.match {
+			t.Errorf("MatchPattern(%q)(%q) = %v, want %v", test.pattern, test.path, got, test.match)
+		}
+	}
+}
+
+func TestReaderSource(t *testing.T) {
+	r := ReaderSource{strings.NewReader("example.com/a\n\n  example.com/b group=x # comment\n# example.com/c\n")}
+	checkPackages(t, "ReaderSource", r, []string{"example.com/a", "example.com/b"})
+}
+
+func TestCombine(t *testing.T) {
+	a := SourceFunc(func() ([]string, error) { return []string{"a", "b"}, nil })
+	b := SourceFunc(func() ([]string, error) { return []string{"b", "c"}, nil })
+	got, err := Combine(a, b).Packages()
+	if want := []string{"a", "b", "c"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestProxySource(t *testing.T) {
+	start := time.Date(2019, 4, 10, 19, 8, 52, 0, time.UTC)
+	var index []proxyEntry
+	for i := 0; i < 5; i++ {
+		index = append(index, proxyEntry{
+			Path:      "example.com/m" + strconv.Itoa(i/2), // two versions of each module
+			Version:   "v1.0." + strconv.Itoa(i%2),
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/index" {
+			http.NotFound(w, req)
+			return
+		}
+		var since time.Time
+		if s := req.FormValue("since"); s != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		limit, _ := strconv.Atoi(req.FormValue("limit"))
+		enc := json.NewEncoder(w)
+		for _, e := range index {
+			if limit == 0 {
+				break
+			}
+			if !e.Timestamp.Before(since) {
+				enc.Encode(e)
+				limit--
+			}
+		}
+	}))
+	defer ts.Close()
+
+	checkPackages(t, "ProxySource", ProxySource{URL: ts.URL}, []string{"example.com/m0", "example.com/m1", "example.com/m2"})
+	checkPackages(t, "ProxySource since", ProxySource{URL: ts.URL + "/", Since: start.Add(3 * time.Minute)}, []string{"example.com/m1", "example.com/m2"})
+	checkPackages(t, "ProxySource max", ProxySource{URL: ts.URL, Max: 2}, []string{"example.com/m0"})
+
+	defer func(n int) { proxyPageSize = n }(proxyPageSize)
+	proxyPageSize = 2
+	checkPackages(t, "ProxySource paged", ProxySource{URL: ts.URL}, []string{"example.com/m0", "example.com/m1", "example.com/m2"})
+	checkPackages(t, "ProxySource paged max", ProxySource{URL: ts.URL, Max: 3}, []string{"example.com/m0", "example.com/m1"})
+	if _, err := (ProxySource{URL: ts.URL + "/missing"}).Packages(); err == nil {
+		t.Error("expected error for missing index")
+	}
+}
@@ -0,0 +1,79 @@
+package gosrc
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// defaultPorts are the ports dropped from canonical URLs, so that the same
+// repository reached over different protocols has the same URL.
+var defaultPorts = map[string]bool{
+	"22":   true, // ssh
+	"80":   true, // http
+	"443":  true, // https
+	"9418": true, // git
+}
+
+// launchpadBranchHost is the host from which bzr fetches Launchpad branches.
+// Its URLs are normalized to the lp: form's host, launchpad.net.
+const launchpadBranchHost = "bazaar.launchpad.net"
+
+// NormalizeURL maps a VCS remote URL to a canonical form: the host and path
+// of the repository, without scheme, user, default port, trailing slash or
+// .git suffix. For example https://github.com/kisielk/gosrc.git,
+// git@github.com:kisielk/gosrc and ssh://git@github.com/kisielk/gosrc/ all
+// become github.com/kisielk/gosrc. URLs that don't name a remote host, such
+// as local paths, are returned unchanged.
+func NormalizeURL(raw string) string {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return ""
+	}
+	if strings.HasPrefix(s, "lp:") {
+		// Launchpad's short form, used by bzr.
+		s = "https://launchpad.net/" + strings.TrimPrefix(s[len("lp:"):], "/")
+	} else if !strings.Contains(s, "://") {
+		// scp-like syntax: [user@]host:path
+		i := strings.Index(s, ":")
+		if i <= 0 || strings.Contains(s[:i], "/") {
+			return raw
+		}
+		s = "ssh://" + s[:i] + "/" + strings.TrimPrefix(s[i+1:], "/")
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	host := strings.ToLower(u.Host)
+	if h, port, err := net.SplitHostPort(host); err == nil && defaultPorts[port] {
+		host = h
+	}
+	p := strings.TrimSuffix(path.Clean("/"+u.Path), "/")
+	p = strings.TrimSuffix(p, ".git")
+	if host == launchpadBranchHost {
+		// Branches served by bzr, including the development focus of
+		// a project, which lp: names without the +branch prefix.
+		host = "launchpad.net"
+		if rest := strings.TrimPrefix(p, "/+branch"); rest != p && rest != "" {
+			p = rest
+		}
+	}
+	return host + p
+}
+
+// RedactURL removes the user information, which may hold credentials, from
+// a VCS remote URL with a scheme. Other URLs are returned unchanged.
+func RedactURL(raw string) string {
+	if !strings.Contains(raw, "://") {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,206 @@
+package gosrc
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ImportCheck is the result of validating the go-import and go-source meta
+// tags served for a vanity import path.
+type ImportCheck struct {
+	// Checked is false for import paths on known hosts, which don't serve
+	// meta tags.
+	Checked bool
+
+	// The repository declared by the go-import tag.
+	VCS, Repo, Root string
+
+	// Source is the content of the go-source tag, if there is one.
+	Source string
+
+	Problems []string
+}
+
+// OK reports whether no problems were found.
+func (c ImportCheck) OK() bool {
+	return len(c.Problems) == 0
+}
+
+func (c *ImportCheck) problem(format string, args ...interface{}) {
+	c.Problems = append(c.Problems, fmt.Sprintf(format, args...))
+}
+
+// knownVCS are the VCS types go get accepts in go-import tags.
+var knownVCS = map[string]bool{"git": true, "hg": true, "bzr": true, "svn": true, "fossil": true}
+
+// CheckImport fetches and validates the go-import and go-source meta tags
+// for importPath. It checks that exactly one go-import tag matches, that its
+// VCS is known, that the page at the repository root declares the same
+// repository, that the repository is reachable, and that any go-source tag
+// is well formed and has the same prefix.
+//
+// The result for the first package checked under a go-import root is reused
+// for the other packages under it, so that each root is only probed once.
+func (r *Resolver) CheckImport(importPath string) ImportCheck {
+	var c ImportCheck
+	if _, ok, err := knownRoot(importPath); ok || err != nil {
+		return c
+	}
+	if !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".") {
+		return c
+	}
+	if c, ok := r.cachedCheck(importPath); ok {
+		return c
+	}
+	c.Checked = true
+	r.checkImport(&c, importPath)
+	if c.Root != "" {
+		r.mu.Lock()
+		if r.checks == nil {
+			r.checks = make(map[string]ImportCheck)
+		}
+		r.checks[c.Root] = c
+		r.mu.Unlock()
+	}
+	return c
+}
+
+// cachedCheck returns the result of a previous check of a go-import root
+// containing importPath.
+func (r *Resolver) cachedCheck(importPath string) (ImportCheck, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range parentPaths(importPath) {
+		if c, ok := r.checks[p]; ok {
+			c.Problems = append([]string(nil), c.Problems...)
+			return c, true
+		}
+	}
+	return ImportCheck{}, false
+}
+
+// checkImport does the checks for CheckImport, recording the results in c.
+func (r *Resolver) checkImport(c *ImportCheck, importPath string) {
+	metas, err := r.fetchMeta(importPath)
+	if err != nil {
+		c.problem("fetching meta tags: %s", err)
+		return
+	}
+	im, err := matchGoImport(goImports(metas), importPath)
+	if err != nil {
+		c.problem("%s", err)
+		return
+	}
+	c.VCS, c.Repo, c.Root = im.VCS, im.Repo, im.Root
+
+	if !knownVCS[im.VCS] {
+		c.problem("go-import tag has unknown VCS %q", im.VCS)
+	}
+	if root, ok, _ := knownRoot(hostPath(im.Repo)); ok && root.VCS != im.VCS {
+		c.problem("go-import tag declares %s, but %s is a %s repository", im.VCS, im.Repo, root.VCS)
+	}
+	if im.Root != importPath {
+		// go get confirms the root against the page served for it.
+		if metas, err := r.fetchMeta(im.Root); err != nil {
+			c.problem("fetching meta tags for root %s: %s", im.Root, err)
+		} else if rim, err := matchGoImport(goImports(metas), im.Root); err != nil {
+			c.problem("root %s: %s", im.Root, err)
+		} else if rim != im {
+			c.problem("root %s declares %s %s, not %s %s", im.Root, rim.VCS, rim.Repo, im.VCS, im.Repo)
+		}
+	}
+	if err := r.reachable(im.VCS, im.Repo); err != nil {
+		c.problem("repository unreachable: %s", err)
+	}
+	r.checkSource(c, metas, importPath)
+}
+
+// checkSource validates the go-source tag matching importPath, if any.
+func (r *Resolver) checkSource(c *ImportCheck, metas []meta, importPath string) {
+	for _, m := range metas {
+		if m.name != "go-source" {
+			continue
+		}
+		f := strings.Fields(m.content)
+		if len(f) != 4 {
+			c.problem("malformed go-source tag %q", m.content)
+			continue
+		}
+		if importPath != f[0] && !strings.HasPrefix(importPath, f[0]+"/") {
+			continue
+		}
+		if c.Source != "" {
+			c.problem("multiple go-source tags match %s", importPath)
+			continue
+		}
+		c.Source = m.content
+		if f[0] != c.Root {
+			c.problem("go-source prefix %s does not match go-import prefix %s", f[0], c.Root)
+		}
+		for i, field := range f[1:] {
+			if field == "_" {
+				continue
+			}
+			if u, err := url.Parse(field); err != nil || u.Host == "" {
+				c.problem("go-source %s %q is not a URL", sourceFields[i], field)
+			}
+		}
+		if f[3] != "_" && !strings.Contains(f[3], "{file}") {
+			c.problem("go-source file template %q has no {file}", f[3])
+		}
+	}
+}
+
+var sourceFields = []string{"home", "directory template", "file template"}
+
+// reachable checks that the repository at repo answers requests. Only
+// repositories served over HTTP can be checked.
+func (r *Resolver) reachable(vcs, repo string) error {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	probe := repo
+	switch vcs {
+	case "git":
+		probe = strings.TrimSuffix(repo, "/") + "/info/refs?service=git-upload-pack"
+	case "hg":
+		probe = repo + "?cmd=capabilities"
+	}
+	resp, err := r.client().Get(probe)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s: %s", probe, resp.Status)
+	}
+	return nil
+}
+
+// CompareRepository records problems if repo, the repository downloaded for
+// the import path, isn't the one declared by the go-import tag.
+func (c *ImportCheck) CompareRepository(repo Repository) {
+	if !c.Checked || c.Repo == "" || repo.Type == "" {
+		return
+	}
+	if c.VCS != repo.Type {
+		c.problem("go-import tag declares %s, but %s was downloaded", c.VCS, repo.Type)
+	}
+	if repo.URL != "" && NormalizeURL(c.Repo) != repo.URL {
+		c.problem("go-import tag declares %s, but the repository was cloned from %s", c.Repo, repo.RawURL)
+	}
+}
+
+// hostPath returns the host and path of a URL, like an import path.
+func hostPath(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Host + strings.TrimSuffix(u.Path, "/")
+}
@@ -0,0 +1,117 @@
+package gosrc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckImport(t *testing.T) {
+	var ts *httptest.Server
+	requests := 0
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		host := req.Host
+		page := func(tags ...string) {
+			fmt.Fprintf(w, "<html><head>\n%s\n</head><body></body></html>", strings.Join(tags, "\n"))
+		}
+		goImport := func(prefix, vcs, repo string) string {
+			return fmt.Sprintf(`<meta name="go-import" content="%s/%s %s %s">`, host, prefix, vcs, repo)
+		}
+		goSource := func(prefix, rest string) string {
+			return fmt.Sprintf(`<meta name="go-source" content="%s/%s %s">`, host, prefix, rest)
+		}
+		p := req.URL.Path
+		switch {
+		case p == "/repo/good.git/info/refs":
+			fmt.Fprintln(w, "refs")
+		case strings.HasPrefix(p, "/good"):
+			page(goImport("good", "git", ts.URL+"/repo/good.git"),
+				goSource("good", "https://example.org/good https://example.org/good/tree{/dir} https://example.org/good/blob{/dir}/{file}#L{line}"))
+		case p == "/badroot":
+			page(goImport("badroot", "git", ts.URL+"/repo/other.git"))
+		case strings.HasPrefix(p, "/badroot"):
+			page(goImport("badroot", "git", ts.URL+"/repo/good.git"))
+		case strings.HasPrefix(p, "/unreachable"):
+			page(goImport("unreachable", "git", ts.URL+"/repo/missing.git"))
+		case strings.HasPrefix(p, "/badsource"):
+			page(goImport("badsource", "git", ts.URL+"/repo/good.git"),
+				goSource("badsource/sub", "_ notaurl https://example.org/blob"))
+		case strings.HasPrefix(p, "/wrongvcs"):
+			page(goImport("wrongvcs", "hg", "git://github.com/kisielk/gosrc"))
+		case p == "/repo/fossil":
+			fmt.Fprintln(w, "fossil")
+		case strings.HasPrefix(p, "/fossil"):
+			page(goImport("fossil", "fossil", ts.URL+"/repo/fossil"))
+		case strings.HasPrefix(p, "/cvs"):
+			page(goImport("cvs", "cvs", "git://cvs.example.org/repo"))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+	r := &Resolver{Insecure: true}
+
+	var tests = []struct {
+		path     string
+		problems []string // fragments of the expected problems, in order
+	}{
+		{"good", nil},
+		{"good/sub", nil},
+		{"badroot/sub", []string{"root " + host + "/badroot declares git " + ts.URL + "/repo/other.git"}},
+		{"unreachable", []string{"repository unreachable", "404"}},
+		{"badsource/sub", []string{
+			"does not match go-import prefix",
+			`directory template "notaurl" is not a URL`,
+			"has no {file}",
+		}},
+		{"wrongvcs", []string{"is a git repository"}},
+		{"fossil", nil},
+		{"cvs", []string{`unknown VCS "cvs"`}},
+		{"missing", []string{"no go-import tag"}},
+	}
+	for _, test := range tests {
+		c := r.CheckImport(host + "/" + test.path)
+		if !c.Checked {
+			t.Errorf("%s: not checked", test.path)
+			continue
+		}
+		if len(test.problems) == 0 && !c.OK() {
+			t.Errorf("%s: unexpected problems %q", test.path, c.Problems)
+		}
+		got := strings.Join(c.Problems, "\n")
+		for _, want := range test.problems {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: problems %q do not mention %q", test.path, c.Problems, want)
+			}
+		}
+	}
+
+	// Other packages under a checked root reuse its results.
+	n := requests
+	c := r.CheckImport(host + "/good/other")
+	if c.VCS != "git" || c.Repo != ts.URL+"/repo/good.git" || c.Root != host+"/good" || !strings.HasPrefix(c.Source, host+"/good ") {
+		t.Errorf("good/other: got %+v", c)
+	}
+	if requests != n {
+		t.Errorf("good/other: made %d requests, want none", requests-n)
+	}
+	if c := r.CheckImport("github.com/kisielk/gosrc"); c.Checked {
+		t.Errorf("known host was checked: %+v", c)
+	}
+}
+
+func TestCompareRepository(t *testing.T) {
+	c := ImportCheck{Checked: true, VCS: "git", Repo: "https://github.com/kisielk/gosrc"}
+	c.CompareRepository(Repository{Type: "git", URL: "github.com/kisielk/gosrc", RawURL: "git@github.com:kisielk/gosrc.git"})
+	if !c.OK() {
+		t.Errorf("matching repository: got problems %q", c.Problems)
+	}
+	c.CompareRepository(Repository{Type: "hg", URL: "bitbucket.org/kisielk/gosrc", RawURL: "https://bitbucket.org/kisielk/gosrc"})
+	if len(c.Problems) != 2 {
+		t.Errorf("mismatched repository: got problems %q, want 2", c.Problems)
+	}
+}